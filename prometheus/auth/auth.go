@@ -0,0 +1,69 @@
+// Package auth authenticates the callers of the backend by their bearer tokens and
+// authorizes their requests per cluster and namespace.
+package auth
+
+import (
+	"context"
+)
+
+// UserInfo is the identity of an authenticated caller.
+type UserInfo struct {
+	Name   string
+	UID    string
+	Groups []string
+}
+
+// Attributes describes the request to be authorized, in terms of the Kubernetes
+// resources it reads or modifies.
+type Attributes struct {
+	User      *UserInfo
+	Cluster   string
+	Namespace string
+	Verb      string
+	Group     string
+	Resource  string
+	Name      string
+}
+
+// Authenticator returns the identity of the owner of token in cluster, false is
+// returned if the token is invalid.
+type Authenticator interface {
+	AuthenticateToken(ctx context.Context, cluster, token string) (*UserInfo, bool, error)
+}
+
+// Authorizer decides whether the request described by a is allowed, the reason is
+// returned if it's denied.
+type Authorizer interface {
+	Authorize(ctx context.Context, a *Attributes) (bool, string, error)
+}
+
+// KubeClient sends JSON requests to the REST API of the API server of a cluster.
+type KubeClient interface {
+	Do(ctx context.Context, method, path string, in, out interface{}) error
+}
+
+// KubeClientGetter returns the KubeClient of cluster.
+type KubeClientGetter func(cluster string) (KubeClient, error)
+
+// Route describes the requests of a route in terms of the Kubernetes resources they
+// touch, so they are authorized by what they do but not by their HTTP methods, e.g.
+// the series of a pod are read by POST requests with the metrics in the body.
+type Route struct {
+	Group    string
+	Resource string
+	// Name is the param of the route which is the name of the resource, the
+	// requests of the routes without it are about the collection.
+	Name string
+	// Verbs maps the methods of the requests to the verbs, "*" maps all the others.
+	// The requests whose methods are not mapped are rejected.
+	Verbs map[string]string
+}
+
+// verb returns the verb of the requests of method, or false if they are not allowed.
+func (r *Route) verb(method string) (string, bool) {
+	if verb, ok := r.Verbs[method]; ok {
+		return verb, true
+	}
+	verb, ok := r.Verbs["*"]
+	return verb, ok
+}
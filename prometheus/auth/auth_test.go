@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	beecontext "github.com/astaxie/beego/context"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestTokenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokens, err := NewTokenFile(writeFile(t, dir, "tokens.csv", "t1,alice,1,\"dev, ops\"\nt2,bob,2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, ok, _ := tokens.AuthenticateToken(context.Background(), "ca", "t1")
+	if !ok || !reflect.DeepEqual(user, &UserInfo{Name: "alice", UID: "1", Groups: []string{"dev", "ops"}}) {
+		t.Errorf("unexpected user of t1: %+v, %v", user, ok)
+	}
+	if user, ok, _ := tokens.AuthenticateToken(context.Background(), "ca", "t2"); !ok || user.Name != "bob" || user.Groups != nil {
+		t.Errorf("unexpected user of t2: %+v, %v", user, ok)
+	}
+	if _, ok, _ := tokens.AuthenticateToken(context.Background(), "ca", "t3"); ok {
+		t.Errorf("expected t3 to be invalid")
+	}
+
+	for _, content := range []string{"t1,alice\n", "t1,alice,1\nt1,bob,2\n", ",alice,1\n"} {
+		if _, err := NewTokenFile(writeFile(t, dir, "invalid.csv", content)); err == nil {
+			t.Errorf("expected error of token file %q", content)
+		}
+	}
+}
+
+var (
+	getPod   = &Route{Resource: "pods", Name: ":pod", Verbs: map[string]string{"*": "get"}}
+	listPods = &Route{Resource: "pods", Verbs: map[string]string{"*": "list"}}
+	records  = &Route{Resource: "pods", Name: ":pod", Verbs: map[string]string{"GET": "get", "*": "update"}}
+	rules    = &Route{Group: "monitoring.coreos.com", Resource: "prometheusrules",
+		Verbs: map[string]string{"GET": "list", "POST": "create"}}
+	rule = &Route{Group: "monitoring.coreos.com", Resource: "prometheusrules", Name: ":rule",
+		Verbs: map[string]string{"GET": "get", "PUT": "update", "DELETE": "delete"}}
+)
+
+// filterRequest runs f for the request to url of route, which is not marked if route
+// is nil.
+func filterRequest(f func(*beecontext.Context), route *Route, method, url, token string, params map[string]string) (*httptest.ResponseRecorder, *beecontext.Context) {
+	r := httptest.NewRequest(method, url, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	ctx := beecontext.NewContext()
+	ctx.Reset(w, r)
+	for k, v := range params {
+		ctx.Input.SetParam(k, v)
+	}
+
+	if route != nil {
+		RouteFilter(route)(ctx)
+	}
+	f(ctx)
+	return w, ctx
+}
+
+func TestFilter(t *testing.T) {
+	authn := &TokenFile{tokens: map[string]*UserInfo{
+		"t1": {Name: "alice", Groups: []string{"dev"}},
+	}}
+	authz := &Policy{Rules: []PolicyRule{
+		{Groups: []string{"dev"}, Clusters: []string{"ca"}, Namespaces: []string{"default"}, Verbs: []string{"get", "list"}},
+	}}
+	filter := Filter(authn, authz)
+
+	podURL := "/backend/prometheus/clusters/ca/namespaces/default/pods/foo"
+	podParams := map[string]string{":cluster": "ca", ":namespace": "default", ":pod": "foo"}
+	nsURL := "/backend/prometheus/clusters/ca/namespaces/default"
+	nsParams := map[string]string{":cluster": "ca", ":namespace": "default"}
+
+	for _, c := range []struct {
+		route  *Route
+		method string
+		url    string
+		token  string
+		params map[string]string
+		code   int
+	}{
+		{getPod, "GET", podURL, "", podParams, http.StatusUnauthorized},
+		{getPod, "GET", podURL, "t2", podParams, http.StatusUnauthorized},
+		{getPod, "GET", podURL, "t1", podParams, http.StatusOK},
+		{records, "DELETE", podURL + "/metrics-records", "t1", podParams, http.StatusForbidden},
+		{records, "POST", podURL + "/metrics-records", "t1", podParams, http.StatusForbidden},
+		{records, "GET", podURL + "/metrics-records", "t1", podParams, http.StatusOK},
+		{&Route{Resource: "namespaces", Verbs: map[string]string{"GET": "list"}},
+			"GET", "/backend/prometheus/clusters/ca/namespaces", "t1", map[string]string{":cluster": "ca"}, http.StatusForbidden},
+		{getPod, "GET", "/backend/prometheus/clusters/cb/namespaces/default/pods/foo", "t1",
+			map[string]string{":cluster": "cb", ":namespace": "default", ":pod": "foo"}, http.StatusForbidden},
+		// The queries are reads whatever the methods are.
+		{getPod, "POST", podURL + "/series", "t1", podParams, http.StatusOK},
+		{listPods, "POST", nsURL + "/query", "t1", nsParams, http.StatusOK},
+		{listPods, "POST", nsURL + "/query_range", "t1", nsParams, http.StatusOK},
+		{rules, "GET", nsURL + "/rules", "t1", nsParams, http.StatusOK},
+		{rules, "POST", nsURL + "/rules", "t1", nsParams, http.StatusForbidden},
+		// beego would run the handler of DELETE for the POST request.
+		{getPod, "POST", podURL + "/series?_method=DELETE", "t1", podParams, http.StatusBadRequest},
+		{records, "POST", podURL + "/metrics-records?_method=PUT", "t1", podParams, http.StatusBadRequest},
+		{rule, "PATCH", nsURL + "/rules/foo", "t1", map[string]string{":cluster": "ca", ":namespace": "default", ":rule": "foo"}, http.StatusMethodNotAllowed},
+		// The routes without authorization are denied.
+		{nil, "GET", podURL, "t1", podParams, http.StatusForbidden},
+	} {
+		w, ctx := filterRequest(filter, c.route, c.method, c.url, c.token, c.params)
+		if w.Code != c.code {
+			t.Errorf("%s %s with token %q: expected status code %d, got %d: %s", c.method, c.url, c.token, c.code, w.Code, w.Body.String())
+		}
+		if user := User(ctx); (user != nil) != (c.code == http.StatusOK) {
+			t.Errorf("%s %s with token %q: unexpected user %+v", c.method, c.url, c.token, user)
+		}
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	user := &UserInfo{Name: "alice"}
+	for _, c := range []struct {
+		route    *Route
+		method   string
+		url      string
+		params   map[string]string
+		expected Attributes
+	}{
+		{
+			getPod, "GET", "/backend/prometheus/clusters/ca/namespaces/default/pods/foo/series",
+			map[string]string{":cluster": "ca", ":namespace": "default", ":pod": "foo"},
+			Attributes{User: user, Cluster: "ca", Namespace: "default", Verb: "get", Resource: "pods", Name: "foo"},
+		},
+		{
+			getPod, "POST", "/backend/prometheus/clusters/ca/namespaces/default/pods/rules/series",
+			map[string]string{":cluster": "ca", ":namespace": "default", ":pod": "rules"},
+			Attributes{User: user, Cluster: "ca", Namespace: "default", Verb: "get", Resource: "pods", Name: "rules"},
+		},
+		{
+			listPods, "GET", "/backend/prometheus/clusters/ca/namespaces/default/pods",
+			map[string]string{":cluster": "ca", ":namespace": "default"},
+			Attributes{User: user, Cluster: "ca", Namespace: "default", Verb: "list", Resource: "pods"},
+		},
+		{
+			records, "POST", "/backend/prometheus/clusters/ca/namespaces/default/pods/foo/metrics-records",
+			map[string]string{":cluster": "ca", ":namespace": "default", ":pod": "foo"},
+			Attributes{User: user, Cluster: "ca", Namespace: "default", Verb: "update", Resource: "pods", Name: "foo"},
+		},
+		{
+			rule, "PUT", "/backend/prometheus/clusters/ca/namespaces/default/rules/foo",
+			map[string]string{":cluster": "ca", ":namespace": "default", ":rule": "foo"},
+			Attributes{User: user, Cluster: "ca", Namespace: "default", Verb: "update",
+				Group: "monitoring.coreos.com", Resource: "prometheusrules", Name: "foo"},
+		},
+		{
+			listPods, "GET", "/backend/prometheus/clusters/ca/namespaces/default/alerting-rules",
+			map[string]string{":cluster": "ca", ":namespace": "default"},
+			Attributes{User: user, Cluster: "ca", Namespace: "default", Verb: "list", Resource: "pods"},
+		},
+		{
+			&Route{Resource: "nodes", Name: ":node", Verbs: map[string]string{"*": "get"}},
+			"GET", "/backend/prometheus/clusters/ca/nodes/n1",
+			map[string]string{":cluster": "ca", ":node": "n1"},
+			Attributes{User: user, Cluster: "ca", Verb: "get", Resource: "nodes", Name: "n1"},
+		},
+	} {
+		var a *Attributes
+		filterRequest(func(ctx *beecontext.Context) {
+			a, _ = attributes(ctx, c.route, user)
+		}, nil, c.method, c.url, "", c.params)
+
+		if a == nil || !reflect.DeepEqual(*a, c.expected) {
+			t.Errorf("%s %s: expected attributes %+v, got %+v", c.method, c.url, c.expected, a)
+		}
+	}
+}
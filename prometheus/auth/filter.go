@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+	beecontext "github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/logs"
+)
+
+const (
+	// userKey is the key of the UserInfo of the caller in the data of the request.
+	userKey = "User"
+	// routeKey is the key of the Route of the request in the data of the request.
+	routeKey = "AuthRoute"
+)
+
+// NewAuthenticator returns the Authenticator of mode, nil is returned if mode is
+// "none" which disables the authentication.
+func NewAuthenticator(mode, tokenFile string, clients KubeClientGetter, ttl time.Duration) (Authenticator, error) {
+	switch mode {
+	case "none":
+		return nil, nil
+	case "token-file":
+		return NewTokenFile(tokenFile)
+	case "token-review":
+		return NewTokenReview(clients, ttl), nil
+	default:
+		return nil, fmt.Errorf("unknown authentication mode %q", mode)
+	}
+}
+
+// NewAuthorizer returns the Authorizer of mode, nil is returned if mode is "none"
+// which allows all the authenticated callers.
+func NewAuthorizer(mode, policyFile string, clients KubeClientGetter, ttl time.Duration) (Authorizer, error) {
+	switch mode {
+	case "none":
+		return nil, nil
+	case "policy":
+		return NewPolicy(policyFile)
+	case "subject-access-review":
+		return NewSubjectAccessReview(clients, ttl), nil
+	default:
+		return nil, fmt.Errorf("unknown authorization mode %q", mode)
+	}
+}
+
+// RouteFilter returns the filter which marks the requests of a route with r. It should
+// be inserted at BeforeExec with the pattern of the route, before the filter returned
+// by Filter, as the pattern of the matched route is only known after BeforeExec.
+func RouteFilter(r *Route) beego.FilterFunc {
+	return func(ctx *beecontext.Context) {
+		ctx.Input.SetData(routeKey, r)
+	}
+}
+
+// Filter returns the filter which authenticates the caller with authn and authorizes
+// the request with authz if it's not nil. It should be inserted at BeforeExec, as the
+// params of the route are needed. The requests of the routes which are not marked by
+// RouteFilter are denied.
+func Filter(authn Authenticator, authz Authorizer) beego.FilterFunc {
+	return func(ctx *beecontext.Context) {
+		// beego runs the handler of the method in _method of a POST request, which
+		// would be authorized as a POST request.
+		if ctx.Input.Query("_method") != "" {
+			writeError(ctx, http.StatusBadRequest, "bad_data", "_method is not supported")
+			return
+		}
+
+		route, ok := ctx.Input.GetData(routeKey).(*Route)
+		if !ok {
+			logs.Error("No authorization of route for %s %s", ctx.Input.Method(), ctx.Input.URL())
+			writeError(ctx, http.StatusForbidden, "forbidden", "no authorization of the route")
+			return
+		}
+
+		token := bearerToken(ctx.Input.Header("Authorization"))
+		if token == "" {
+			ctx.Output.Header("WWW-Authenticate", `Bearer realm="prometheus-backend"`)
+			writeError(ctx, http.StatusUnauthorized, "unauthorized", "bearer token required")
+			return
+		}
+
+		cluster := ctx.Input.Param(":cluster")
+		user, ok, err := authn.AuthenticateToken(ctx.Request.Context(), cluster, token)
+		if err != nil {
+			logs.Error("Authenticate token of cluster %s failed: %v", cluster, err)
+			writeError(ctx, http.StatusServiceUnavailable, "unavailable", fmt.Sprintf("authenticate failed: %v", err))
+			return
+		}
+		if !ok {
+			ctx.Output.Header("WWW-Authenticate", `Bearer realm="prometheus-backend", error="invalid_token"`)
+			writeError(ctx, http.StatusUnauthorized, "unauthorized", "invalid bearer token")
+			return
+		}
+
+		a, ok := attributes(ctx, route, user)
+		if !ok {
+			writeError(ctx, http.StatusMethodNotAllowed, "bad_data", fmt.Sprintf("method %s is not allowed", ctx.Input.Method()))
+			return
+		}
+		if authz != nil {
+			allowed, reason, err := authz.Authorize(ctx.Request.Context(), a)
+			if err != nil {
+				logs.Error("Authorize user %s failed: %v", user.Name, err)
+				writeError(ctx, http.StatusServiceUnavailable, "unavailable", fmt.Sprintf("authorize failed: %v", err))
+				return
+			}
+			if !allowed {
+				logs.Warn("user: %s, groups: %v, %s %s denied: %s", user.Name, user.Groups, ctx.Input.Method(), ctx.Input.URL(), reason)
+				msg := fmt.Sprintf("user %q cannot %s %s in cluster %q", user.Name, a.Verb, a.Resource, a.Cluster)
+				if a.Namespace != "" {
+					msg += fmt.Sprintf(" namespace %q", a.Namespace)
+				}
+				writeError(ctx, http.StatusForbidden, "forbidden", msg)
+				return
+			}
+		}
+
+		ctx.Input.SetData(userKey, user)
+		logs.Info("user: %s, groups: %v, %s %s", user.Name, user.Groups, ctx.Input.Method(), ctx.Input.URL())
+	}
+}
+
+// User returns the authenticated caller of the request, nil is returned if the
+// authentication is disabled.
+func User(ctx *beecontext.Context) *UserInfo {
+	user, _ := ctx.Input.GetData(userKey).(*UserInfo)
+	return user
+}
+
+// attributes returns the Attributes of the request of user to route, false is
+// returned if the method of the request is not allowed.
+func attributes(ctx *beecontext.Context, route *Route, user *UserInfo) (*Attributes, bool) {
+	verb, ok := route.verb(ctx.Input.Method())
+	if !ok {
+		return nil, false
+	}
+
+	a := &Attributes{
+		User:      user,
+		Cluster:   ctx.Input.Param(":cluster"),
+		Namespace: ctx.Input.Param(":namespace"),
+		Verb:      verb,
+		Group:     route.Group,
+		Resource:  route.Resource,
+	}
+	if route.Name != "" {
+		a.Name = ctx.Input.Param(route.Name)
+	}
+
+	return a, true
+}
+
+func bearerToken(header string) string {
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+// writeError writes the error in the format of the results of the controller.
+func writeError(ctx *beecontext.Context, code int, typ, msg string) {
+	b, _ := json.Marshal(map[string]string{
+		"status":    "error",
+		"errorType": typ,
+		"error":     msg,
+	})
+
+	ctx.Output.Header("Content-Type", "application/json")
+	ctx.Output.SetStatus(code)
+	if err := ctx.Output.Body(b); err != nil {
+		logs.Error("Write response body failed: %v", err)
+	}
+}
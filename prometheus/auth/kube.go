@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tokenReviewPath         = "/apis/authentication.k8s.io/v1/tokenreviews"
+	subjectAccessReviewPath = "/apis/authorization.k8s.io/v1/subjectaccessreviews"
+
+	// maxCacheEntries bounds the number of cached reviews.
+	maxCacheEntries = 10000
+)
+
+type tokenReview struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Spec       tokenReviewSpec   `json:"spec"`
+	Status     tokenReviewStatus `json:"status,omitempty"`
+}
+
+type tokenReviewSpec struct {
+	Token string `json:"token"`
+}
+
+type tokenReviewStatus struct {
+	Authenticated bool `json:"authenticated"`
+	User          struct {
+		Username string   `json:"username"`
+		UID      string   `json:"uid"`
+		Groups   []string `json:"groups"`
+	} `json:"user"`
+	Error string `json:"error,omitempty"`
+}
+
+// tokenKey is the key of a cached TokenReview, token is the hash of the token.
+type tokenKey struct {
+	cluster string
+	token   string
+}
+
+// TokenReview authenticates the callers with the TokenReview API of the API server of
+// the cluster they request, so they could use their tokens of Kubernetes. The reviews
+// are cached for ttl to save the round trips.
+type TokenReview struct {
+	clients KubeClientGetter
+	cache   *reviewCache
+}
+
+func NewTokenReview(clients KubeClientGetter, ttl time.Duration) *TokenReview {
+	return &TokenReview{
+		clients: clients,
+		cache:   newReviewCache(ttl),
+	}
+}
+
+func (t *TokenReview) AuthenticateToken(ctx context.Context, cluster, token string) (*UserInfo, bool, error) {
+	// Never keep the tokens themselves in memory.
+	sum := sha256.Sum256([]byte(token))
+	key := tokenKey{cluster: cluster, token: hex.EncodeToString(sum[:])}
+	if v, ok := t.cache.get(key); ok {
+		user, _ := v.(*UserInfo)
+		return user, user != nil, nil
+	}
+
+	client, err := t.clients(cluster)
+	if err != nil {
+		return nil, false, err
+	}
+
+	review := &tokenReview{
+		APIVersion: "authentication.k8s.io/v1",
+		Kind:       "TokenReview",
+		Spec:       tokenReviewSpec{Token: token},
+	}
+	if err := client.Do(ctx, http.MethodPost, tokenReviewPath, review, review); err != nil {
+		return nil, false, err
+	}
+
+	var user *UserInfo
+	if review.Status.Authenticated {
+		user = &UserInfo{
+			Name:   review.Status.User.Username,
+			UID:    review.Status.User.UID,
+			Groups: review.Status.User.Groups,
+		}
+	}
+	t.cache.set(key, user)
+
+	return user, user != nil, nil
+}
+
+type subjectAccessReview struct {
+	APIVersion string                    `json:"apiVersion"`
+	Kind       string                    `json:"kind"`
+	Spec       subjectAccessReviewSpec   `json:"spec"`
+	Status     subjectAccessReviewStatus `json:"status,omitempty"`
+}
+
+type subjectAccessReviewSpec struct {
+	ResourceAttributes *resourceAttributes `json:"resourceAttributes"`
+	User               string              `json:"user"`
+	UID                string              `json:"uid,omitempty"`
+	Groups             []string            `json:"groups,omitempty"`
+}
+
+type resourceAttributes struct {
+	Namespace string `json:"namespace,omitempty"`
+	Verb      string `json:"verb"`
+	Group     string `json:"group,omitempty"`
+	Resource  string `json:"resource"`
+	Name      string `json:"name,omitempty"`
+}
+
+type subjectAccessReviewStatus struct {
+	Allowed         bool   `json:"allowed"`
+	Denied          bool   `json:"denied,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+	EvaluationError string `json:"evaluationError,omitempty"`
+}
+
+// SubjectAccessReview authorizes the requests with the SubjectAccessReview API of the
+// API server of the cluster they request, so the RBAC of the cluster applies to the
+// backend as well. The reviews are cached for ttl.
+type SubjectAccessReview struct {
+	clients KubeClientGetter
+	cache   *reviewCache
+}
+
+func NewSubjectAccessReview(clients KubeClientGetter, ttl time.Duration) *SubjectAccessReview {
+	return &SubjectAccessReview{
+		clients: clients,
+		cache:   newReviewCache(ttl),
+	}
+}
+
+// accessKey is the key of a cached SubjectAccessReview.
+type accessKey struct {
+	cluster   string
+	user      string
+	uid       string
+	groups    string
+	namespace string
+	verb      string
+	group     string
+	resource  string
+	name      string
+}
+
+func newAccessKey(a *Attributes) accessKey {
+	// The groups are quoted so they are joined unambiguously.
+	groups := make([]string, len(a.User.Groups))
+	for i, g := range a.User.Groups {
+		groups[i] = strconv.Quote(g)
+	}
+
+	return accessKey{
+		cluster:   a.Cluster,
+		user:      a.User.Name,
+		uid:       a.User.UID,
+		groups:    strings.Join(groups, ","),
+		namespace: a.Namespace,
+		verb:      a.Verb,
+		group:     a.Group,
+		resource:  a.Resource,
+		name:      a.Name,
+	}
+}
+
+type accessDecision struct {
+	allowed bool
+	reason  string
+}
+
+func (s *SubjectAccessReview) Authorize(ctx context.Context, a *Attributes) (bool, string, error) {
+	key := newAccessKey(a)
+	if v, ok := s.cache.get(key); ok {
+		d := v.(*accessDecision)
+		return d.allowed, d.reason, nil
+	}
+
+	client, err := s.clients(a.Cluster)
+	if err != nil {
+		return false, "", err
+	}
+
+	review := &subjectAccessReview{
+		APIVersion: "authorization.k8s.io/v1",
+		Kind:       "SubjectAccessReview",
+		Spec: subjectAccessReviewSpec{
+			ResourceAttributes: &resourceAttributes{
+				Namespace: a.Namespace,
+				Verb:      a.Verb,
+				Group:     a.Group,
+				Resource:  a.Resource,
+				Name:      a.Name,
+			},
+			User:   a.User.Name,
+			UID:    a.User.UID,
+			Groups: a.User.Groups,
+		},
+	}
+	if err := client.Do(ctx, http.MethodPost, subjectAccessReviewPath, review, review); err != nil {
+		return false, "", err
+	}
+
+	d := &accessDecision{
+		allowed: review.Status.Allowed && !review.Status.Denied,
+		reason:  review.Status.Reason,
+	}
+	s.cache.set(key, d)
+
+	return d.allowed, d.reason, nil
+}
+
+// reviewCache caches the results of the reviews for ttl, keyed by comparable values
+// such as tokenKey and accessKey. When it is full, it's simply cleared, as the entries
+// are cheap to get again.
+type reviewCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	entries map[interface{}]*reviewEntry
+}
+
+type reviewEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func newReviewCache(ttl time.Duration) *reviewCache {
+	return &reviewCache{
+		ttl:     ttl,
+		entries: make(map[interface{}]*reviewEntry),
+	}
+}
+
+func (c *reviewCache) get(key interface{}) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	return e.value, true
+}
+
+func (c *reviewCache) set(key interface{}, value interface{}) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.entries) >= maxCacheEntries {
+		c.entries = make(map[interface{}]*reviewEntry)
+	}
+	c.entries[key] = &reviewEntry{
+		value:   value,
+		expires: time.Now().Add(c.ttl),
+	}
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/YaoZengzeng/practice/prometheus/controller"
+)
+
+// fakeAPIServer serves the TokenReviews and SubjectAccessReviews, the token "t1" is
+// of alice, who could only get the pods of namespace default.
+func fakeAPIServer(t *testing.T, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer backend" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"kind":"Status","status":"Failure","reason":"Forbidden","code":403}`))
+			return
+		}
+
+		switch r.URL.Path {
+		case tokenReviewPath:
+			review := &tokenReview{}
+			if err := json.NewDecoder(r.Body).Decode(review); err != nil {
+				t.Errorf("decode TokenReview failed: %v", err)
+			}
+			if review.Spec.Token == "t1" {
+				review.Status.Authenticated = true
+				review.Status.User.Username = "alice"
+				review.Status.User.UID = "1"
+				review.Status.User.Groups = []string{"system:authenticated"}
+			}
+			json.NewEncoder(w).Encode(review)
+
+		case subjectAccessReviewPath:
+			review := &subjectAccessReview{}
+			if err := json.NewDecoder(r.Body).Decode(review); err != nil {
+				t.Errorf("decode SubjectAccessReview failed: %v", err)
+			}
+			attrs := review.Spec.ResourceAttributes
+			if review.Spec.User == "alice" && attrs.Namespace == "default" && attrs.Resource == "pods" && attrs.Verb == "get" {
+				review.Status.Allowed = true
+			} else {
+				review.Status.Reason = "RBAC: access denied"
+			}
+			json.NewEncoder(w).Encode(review)
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func kubeClients(t *testing.T, url, token string) KubeClientGetter {
+	client, err := controller.NewKubeClient(&controller.DataSource{Url: url, Token: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(cluster string) (KubeClient, error) {
+		return client, nil
+	}
+}
+
+func TestTokenReview(t *testing.T) {
+	var requests int
+	server := fakeAPIServer(t, &requests)
+	defer server.Close()
+
+	authn := NewTokenReview(kubeClients(t, server.URL, "backend"), time.Minute)
+	for i := 0; i < 2; i++ {
+		user, ok, err := authn.AuthenticateToken(context.Background(), "ca", "t1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || !reflect.DeepEqual(user, &UserInfo{Name: "alice", UID: "1", Groups: []string{"system:authenticated"}}) {
+			t.Errorf("unexpected user of t1: %+v, %v", user, ok)
+		}
+
+		if _, ok, err := authn.AuthenticateToken(context.Background(), "ca", "t2"); err != nil || ok {
+			t.Errorf("expected t2 to be invalid, got %v, %v", ok, err)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("expected the reviews to be cached, got %d requests", requests)
+	}
+
+	// The backend isn't allowed to review the tokens.
+	authn = NewTokenReview(kubeClients(t, server.URL, "invalid"), time.Minute)
+	if _, _, err := authn.AuthenticateToken(context.Background(), "ca", "t1"); err == nil {
+		t.Errorf("expected error of the forbidden review")
+	}
+}
+
+func TestSubjectAccessReview(t *testing.T) {
+	var requests int
+	server := fakeAPIServer(t, &requests)
+	defer server.Close()
+
+	authz := NewSubjectAccessReview(kubeClients(t, server.URL, "backend"), time.Minute)
+	alice := &UserInfo{Name: "alice", UID: "1"}
+	for _, c := range []struct {
+		attributes Attributes
+		allowed    bool
+	}{
+		{Attributes{User: alice, Cluster: "ca", Namespace: "default", Verb: "get", Resource: "pods", Name: "foo"}, true},
+		{Attributes{User: alice, Cluster: "ca", Namespace: "default", Verb: "get", Resource: "pods", Name: "foo"}, true},
+		{Attributes{User: alice, Cluster: "ca", Namespace: "kube-system", Verb: "get", Resource: "pods", Name: "foo"}, false},
+		{Attributes{User: alice, Cluster: "ca", Namespace: "default", Verb: "delete", Resource: "pods", Name: "foo"}, false},
+	} {
+		allowed, reason, err := authz.Authorize(context.Background(), &c.attributes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != c.allowed {
+			t.Errorf("%+v: expected allowed %v, got %v: %s", c.attributes, c.allowed, allowed, reason)
+		}
+	}
+	if requests != 3 {
+		t.Errorf("expected the reviews to be cached, got %d requests", requests)
+	}
+}
+
+func TestAccessKey(t *testing.T) {
+	for _, c := range [][2]Attributes{
+		{
+			{User: &UserInfo{Name: "alice", Groups: []string{"a,b"}}, Cluster: "ca"},
+			{User: &UserInfo{Name: "alice", Groups: []string{"a", "b"}}, Cluster: "ca"},
+		},
+		{
+			{User: &UserInfo{Name: "alice|1"}, Cluster: "ca"},
+			{User: &UserInfo{Name: "alice", UID: "1"}, Cluster: "ca"},
+		},
+		{
+			{User: &UserInfo{Name: "alice"}, Cluster: "ca", Namespace: "default|get"},
+			{User: &UserInfo{Name: "alice"}, Cluster: "ca", Namespace: "default", Verb: "get"},
+		},
+	} {
+		if newAccessKey(&c[0]) == newAccessKey(&c[1]) {
+			t.Errorf("%+v and %+v have the same key", c[0], c[1])
+		}
+	}
+}
+
+func TestFilterTokenReview(t *testing.T) {
+	var requests int
+	server := fakeAPIServer(t, &requests)
+	defer server.Close()
+
+	clients := kubeClients(t, server.URL, "backend")
+	filter := Filter(NewTokenReview(clients, 0), NewSubjectAccessReview(clients, 0))
+	params := map[string]string{":cluster": "ca", ":namespace": "default", ":pod": "foo"}
+	for _, c := range []struct {
+		namespace string
+		token     string
+		code      int
+	}{
+		{"default", "t1", http.StatusOK},
+		{"default", "t2", http.StatusUnauthorized},
+		{"kube-system", "t1", http.StatusForbidden},
+	} {
+		params[":namespace"] = c.namespace
+		w, _ := filterRequest(filter, getPod, "GET", "/backend/prometheus/clusters/ca/namespaces/"+c.namespace+"/pods/foo", c.token, params)
+		if w.Code != c.code {
+			t.Errorf("namespace %s with token %s: expected status code %d, got %d: %s", c.namespace, c.token, c.code, w.Code, w.Body.String())
+		}
+	}
+}
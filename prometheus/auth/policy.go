@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Policy authorizes the requests with the local RBAC rules of a policy file, which
+// looks like:
+//
+//	rules:
+//	- groups: ["system:masters"]
+//	  clusters: ["*"]
+//	  namespaces: ["*"]
+//	  verbs: ["*"]
+//	  resources: ["*"]
+//	- users: ["alice"]
+//	  clusters: ["ca"]
+//	  namespaces: ["default", "monitoring"]
+//	  verbs: ["get"]
+//	  resources: ["pods", "prometheusrules"]
+//
+// A request is allowed if any rule matches it. A rule must name its users or groups,
+// the other fields match everything if they are empty or contain "*". The listing of
+// namespaces has no namespace, so it isn't matched by the rules naming namespaces.
+type Policy struct {
+	Rules []PolicyRule `yaml:"rules"`
+}
+
+type PolicyRule struct {
+	Users      []string `yaml:"users"`
+	Groups     []string `yaml:"groups"`
+	Clusters   []string `yaml:"clusters"`
+	Namespaces []string `yaml:"namespaces"`
+	Verbs      []string `yaml:"verbs"`
+	Resources  []string `yaml:"resources"`
+}
+
+func NewPolicy(filename string) (*Policy, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{}
+	if err := yaml.UnmarshalStrict(b, policy); err != nil {
+		return nil, fmt.Errorf("parse policy file %s failed: %v", filename, err)
+	}
+	for i, rule := range policy.Rules {
+		if len(rule.Users) == 0 && len(rule.Groups) == 0 {
+			return nil, fmt.Errorf("policy file %s rule %d: no users or groups", filename, i)
+		}
+	}
+
+	return policy, nil
+}
+
+func (p *Policy) Authorize(ctx context.Context, a *Attributes) (bool, string, error) {
+	for _, rule := range p.Rules {
+		if rule.matches(a) {
+			return true, "", nil
+		}
+	}
+
+	return false, "no policy rule matches", nil
+}
+
+func (r *PolicyRule) matches(a *Attributes) bool {
+	subject := contains(r.Users, a.User.Name)
+	for _, group := range a.User.Groups {
+		subject = subject || contains(r.Groups, group)
+	}
+	if !subject {
+		return false
+	}
+
+	return matchAll(r.Clusters, a.Cluster) &&
+		matchAll(r.Namespaces, a.Namespace) &&
+		matchAll(r.Verbs, a.Verb) &&
+		matchAll(r.Resources, a.Resource)
+}
+
+// contains returns whether values contains value or "*".
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == "*" || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAll is like contains, but it matches everything if values is empty.
+func matchAll(values []string, value string) bool {
+	return len(values) == 0 || contains(values, value)
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	policy, err := NewPolicy(writeFile(t, dir, "policy.yaml", `
+rules:
+- groups: ["system:masters"]
+  clusters: ["*"]
+  namespaces: ["*"]
+- users: ["alice"]
+  clusters: ["ca"]
+  namespaces: ["default"]
+  verbs: ["get", "list"]
+  resources: ["pods", "prometheusrules"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := &UserInfo{Name: "alice"}
+	admin := &UserInfo{Name: "bob", Groups: []string{"system:authenticated", "system:masters"}}
+	for _, c := range []struct {
+		attributes Attributes
+		allowed    bool
+	}{
+		{Attributes{User: alice, Cluster: "ca", Namespace: "default", Verb: "get", Resource: "pods"}, true},
+		{Attributes{User: alice, Cluster: "ca", Namespace: "default", Verb: "list", Resource: "prometheusrules"}, true},
+		{Attributes{User: alice, Cluster: "ca", Namespace: "default", Verb: "delete", Resource: "pods"}, false},
+		{Attributes{User: alice, Cluster: "ca", Namespace: "kube-system", Verb: "get", Resource: "pods"}, false},
+		{Attributes{User: alice, Cluster: "cb", Namespace: "default", Verb: "get", Resource: "pods"}, false},
+		{Attributes{User: alice, Cluster: "ca", Verb: "list", Resource: "namespaces"}, false},
+		{Attributes{User: admin, Cluster: "cb", Verb: "list", Resource: "namespaces"}, true},
+		{Attributes{User: admin, Cluster: "cb", Namespace: "default", Verb: "delete", Resource: "prometheusrules"}, true},
+		{Attributes{User: &UserInfo{Name: "carol"}, Cluster: "ca", Namespace: "default", Verb: "get", Resource: "pods"}, false},
+	} {
+		allowed, _, err := policy.Authorize(context.Background(), &c.attributes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != c.allowed {
+			t.Errorf("%s of %+v: expected allowed %v, got %v", c.attributes.User.Name, c.attributes, c.allowed, allowed)
+		}
+	}
+
+	for _, content := range []string{"rules:\n- clusters: [\"*\"]\n", "rules:\n- users: [alice]\n  unknown: true\n"} {
+		if _, err := NewPolicy(writeFile(t, dir, "invalid.yaml", content)); err == nil {
+			t.Errorf("expected error of policy %q", content)
+		}
+	}
+}
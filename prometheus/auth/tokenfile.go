@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// TokenFile authenticates the callers with the static tokens of a CSV file, which has
+// the same format as the token file of kube-apiserver:
+//
+//	token,user,uid,"group1,group2"
+//
+// The groups column is optional. The tokens are valid in all the clusters.
+type TokenFile struct {
+	tokens map[string]*UserInfo
+}
+
+func NewTokenFile(filename string) (*TokenFile, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tokens := make(map[string]*UserInfo)
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) < 3 {
+			return nil, fmt.Errorf("token file %s line %d: expected at least 3 columns, got %d", filename, line, len(record))
+		}
+		token := strings.TrimSpace(record[0])
+		if token == "" {
+			return nil, fmt.Errorf("token file %s line %d: empty token", filename, line)
+		}
+		if _, ok := tokens[token]; ok {
+			return nil, fmt.Errorf("token file %s line %d: duplicate token", filename, line)
+		}
+
+		user := &UserInfo{
+			Name: strings.TrimSpace(record[1]),
+			UID:  strings.TrimSpace(record[2]),
+		}
+		if len(record) > 3 {
+			for _, group := range strings.Split(record[3], ",") {
+				if group = strings.TrimSpace(group); group != "" {
+					user.Groups = append(user.Groups, group)
+				}
+			}
+		}
+		tokens[token] = user
+	}
+
+	return &TokenFile{tokens: tokens}, nil
+}
+
+func (t *TokenFile) AuthenticateToken(ctx context.Context, cluster, token string) (*UserInfo, bool, error) {
+	user, ok := t.tokens[token]
+	return user, ok, nil
+}
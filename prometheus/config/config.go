@@ -1,11 +1,373 @@
+// Package config holds the configuration of the backend. The flags are the defaults
+// of the settings, which are overridden by the config file if it's specified. The
+// config file is reloaded on SIGHUP or once it's modified.
 package config
 
 import (
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/YaoZengzeng/practice/prometheus/promql"
+
+	"gopkg.in/yaml.v2"
 )
 
-var PrometheusURL string
+// Config is the configuration of the backend, the config file looks like:
+//
+//   listen_address: :8080
+//   metrics_address: :8081
+//   clusters:
+//     ca:
+//       url: http://10.32.0.2:9090
+//       labels:
+//         namespace: namespace
+//         pod_name: pod
+//   labels:
+//     namespace: kubernetes_namespace
+//     pod_name: kubernetes_pod_name
+//   query:
+//     timeout: 1m
+//   cache:
+//     label_ttl: 5m
+//   store:
+//     kind: mysql
+//     dsn: user:password@tcp(127.0.0.1:3306)/prometheus
+//   auth:
+//     mode: token-review
+//     authz_mode: subject-access-review
+//
+// The listen and metrics addresses, the store, the label cache TTL, the results cache
+// size and the rate limits take effect on restart, all the other settings are applied
+// on reload.
+type Config struct {
+	ListenAddress  string `yaml:"listen_address"`
+	MetricsAddress string `yaml:"metrics_address"`
+
+	// All the clusters share the Prometheus of PrometheusURL if there are neither
+	// Clusters nor ClustersFile.
+	PrometheusURL  string                 `yaml:"prometheus_url"`
+	Clusters       map[string]*DataSource `yaml:"clusters"`
+	ClustersFile   string                 `yaml:"clusters_file"`
+	PodQueriesFile string                 `yaml:"pod_queries_file"`
+
+	Labels     LabelsConfig     `yaml:"labels"`
+	Connection ConnectionConfig `yaml:"connection"`
+	Query      QueryConfig      `yaml:"query"`
+	Cache      CacheConfig      `yaml:"cache"`
+	Store      StoreConfig      `yaml:"store"`
+	Auth       AuthConfig       `yaml:"auth"`
+
+	RateLimitsFile string `yaml:"rate_limits_file"`
+
+	// Labels of the PrometheusRules created by the backend, which should be selected
+	// by the ruleSelector of Prometheus.
+	RuleLabels string `yaml:"rule_labels"`
+}
+
+// DataSource is the Prometheus of a cluster.
+type DataSource struct {
+	Url   string `yaml:"url"`
+	Token string `yaml:"token"`
+
+	// TLS settings to connect to the Prometheus.
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+
+	// Kubernetes is the API server of the cluster, which manages the alert rules.
+	Kubernetes KubernetesSource `yaml:"kubernetes"`
+
+	// Labels override the labels config for the cluster, e.g. the clusters scraped
+	// by prometheus-operator use namespace and pod.
+	Labels LabelsConfig `yaml:"labels"`
+}
+
+// KubernetesSource is the API server of a cluster, the in-cluster config of the
+// backend is used if Url is empty.
+type KubernetesSource struct {
+	Url   string `yaml:"url"`
+	Token string `yaml:"token"`
+
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// LabelsConfig are the names of the labels which identify the pods, they differ if
+// the targets are relabelled.
+type LabelsConfig struct {
+	Namespace string `yaml:"namespace"`
+	PodName   string `yaml:"pod_name"`
+}
+
+// Settings of the connections to Prometheus.
+type ConnectionConfig struct {
+	DialTimeout           time.Duration `yaml:"dial_timeout"`
+	TLSHandshakeTimeout   time.Duration `yaml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"`
+	IdleConnTimeout       time.Duration `yaml:"idle_conn_timeout"`
+	MaxIdleConnsPerHost   int           `yaml:"max_idle_conns_per_host"`
+}
+
+// Settings of the queries fanned out for a single request.
+type QueryConfig struct {
+	Timeout       time.Duration `yaml:"timeout"`
+	Concurrency   int           `yaml:"concurrency"`
+	MaxDataPoints int           `yaml:"max_data_points"`
+}
+
+// Settings of the cache of labels and the cache of range query results.
+type CacheConfig struct {
+	LabelTTL            time.Duration `yaml:"label_ttl"`
+	ResultsSize         int           `yaml:"results_size"`
+	ResultsTTL          time.Duration `yaml:"results_ttl"`
+	ResultsMaxFreshness time.Duration `yaml:"results_max_freshness"`
+}
+
+// Settings of the Store of pod metrics records.
+type StoreConfig struct {
+	Kind string `yaml:"kind"`
+	DSN  string `yaml:"dsn"`
+}
+
+// Settings of the authentication and authorization of the callers.
+type AuthConfig struct {
+	Mode       string        `yaml:"mode"`
+	TokenFile  string        `yaml:"token_file"`
+	AuthzMode  string        `yaml:"authz_mode"`
+	PolicyFile string        `yaml:"policy_file"`
+	CacheTTL   time.Duration `yaml:"cache_ttl"`
+}
+
+var (
+	// ConfigFile is the path of the config file, only the flags are used if empty.
+	ConfigFile string
+
+	// flags holds the settings of the flags.
+	flags Config
+
+	current atomic.Value
+
+	mu       sync.Mutex
+	onReload []func(old, new *Config)
+)
 
 func init() {
-	flag.StringVar(&PrometheusURL, "prom-url", "http://10.32.0.2:9090", "Prometheues URL")
+	flag.StringVar(&ConfigFile, "config", "", "YAML config file, whose settings override the flags")
+	flag.StringVar(&flags.ListenAddress, "listen-address", ":8080", "Address to serve the API on")
+	flag.StringVar(&flags.PrometheusURL, "prom-url", "http://10.32.0.2:9090", "Prometheues URL")
+	flag.StringVar(&flags.PodQueriesFile, "pod-queries", "", "YAML file of the named queries used to monitor a pod, built-in queries are used if empty")
+	flag.StringVar(&flags.ClustersFile, "clusters", "", "YAML file mapping cluster name to its Prometheus, all clusters use prom-url if empty")
+	flag.StringVar(&flags.Labels.Namespace, "namespace-label", "kubernetes_namespace", "Name of the label of the namespace of pods")
+	flag.StringVar(&flags.Labels.PodName, "pod-name-label", "kubernetes_pod_name", "Name of the label of the name of pods")
+	flag.DurationVar(&flags.Connection.DialTimeout, "prom-dial-timeout", 30*time.Second, "Timeout of dialing to Prometheus")
+	flag.DurationVar(&flags.Connection.TLSHandshakeTimeout, "prom-tls-handshake-timeout", 10*time.Second, "Timeout of TLS handshake with Prometheus")
+	flag.DurationVar(&flags.Connection.ResponseHeaderTimeout, "prom-response-header-timeout", time.Minute, "Timeout of waiting for the response headers of Prometheus, zero means no timeout")
+	flag.DurationVar(&flags.Connection.IdleConnTimeout, "prom-idle-conn-timeout", 90*time.Second, "Maximum time an idle connection to Prometheus is kept")
+	flag.IntVar(&flags.Connection.MaxIdleConnsPerHost, "prom-max-idle-conns", 16, "Maximum idle connections kept to each Prometheus")
+	flag.DurationVar(&flags.Query.Timeout, "query-timeout", 30*time.Second, "Overall timeout of the Prometheus queries for a single request")
+	flag.IntVar(&flags.Query.Concurrency, "query-concurrency", 8, "Maximum Prometheus queries in flight for a single request")
+	flag.IntVar(&flags.Query.MaxDataPoints, "max-data-points", 11000, "Maximum data points of a returned series, it should not exceed the limit of Prometheus")
+	flag.StringVar(&flags.Store.Kind, "store", "memory", "Store of pod metrics records, one of memory and mysql")
+	flag.StringVar(&flags.Store.DSN, "store-dsn", "", "Data source name of the store database, e.g. user:password@tcp(127.0.0.1:3306)/prometheus")
+	flag.DurationVar(&flags.Cache.LabelTTL, "label-cache-ttl", time.Minute, "TTL of the cached namespaces, pods and label values, zero disables the cache")
+	flag.IntVar(&flags.Cache.ResultsSize, "results-cache-size", 10000, "Maximum chunks of range query results cached, zero disables the cache")
+	flag.DurationVar(&flags.Cache.ResultsTTL, "results-cache-ttl", 10*time.Second, "TTL of the cached chunks which contain recent data")
+	flag.DurationVar(&flags.Cache.ResultsMaxFreshness, "results-cache-max-freshness", 10*time.Minute, "Chunks newer than this are considered recent, as their data may still change")
+	flag.StringVar(&flags.MetricsAddress, "metrics-address", ":8081", "Address to serve the metrics of the backend itself on, empty disables it")
+	flag.StringVar(&flags.RuleLabels, "rule-labels", "role=alert-rules", "Comma separated name=value labels of the PrometheusRules created by the backend")
+	flag.StringVar(&flags.Auth.Mode, "auth-mode", "none", "Authentication of the bearer tokens of the callers, one of none, token-file and token-review")
+	flag.StringVar(&flags.Auth.TokenFile, "auth-token-file", "", "CSV file of the static tokens in the format of token,user,uid,\"group1,group2\", used by the token-file mode")
+	flag.StringVar(&flags.Auth.AuthzMode, "authz-mode", "none", "Authorization of the requests per cluster and namespace, one of none, policy and subject-access-review")
+	flag.StringVar(&flags.Auth.PolicyFile, "authz-policy-file", "", "YAML file of the RBAC rules, used by the policy mode")
+	flag.DurationVar(&flags.Auth.CacheTTL, "auth-cache-ttl", time.Minute, "TTL of the cached TokenReviews and SubjectAccessReviews, zero disables the cache")
+	flag.StringVar(&flags.RateLimitsFile, "rate-limits-file", "", "YAML file of the rate limits of the callers and clusters per route, no limits if empty")
+
+	// The defaults of the flags are used until Load is called, e.g. in tests.
+	c := flags
+	current.Store(&c)
+}
+
+// Get returns the current Config, it must not be modified.
+func Get() *Config {
+	return current.Load().(*Config)
+}
+
+// Set replaces the current Config with c, the functions registered by OnReload are
+// called with the old one.
+func Set(c *Config) {
+	mu.Lock()
+	old := Get()
+	current.Store(c)
+	funcs := onReload
+	mu.Unlock()
+
+	for _, f := range funcs {
+		f(old, c)
+	}
+}
+
+// OnReload registers f to be called once the Config is replaced.
+func OnReload(f func(old, new *Config)) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	onReload = append(onReload, f)
+}
+
+// Load reads the config file over the flags, and sets the result as the current
+// Config if it's valid. It should be called after the flags are parsed.
+func Load() error {
+	c, err := load(ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	Set(c)
+	return nil
+}
+
+func load(filename string) (*Config, error) {
+	c := flags
+	if filename != "" {
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("read config file failed: %v", err)
+		}
+		if err := yaml.UnmarshalStrict(b, &c); err != nil {
+			return nil, fmt.Errorf("parse config file %s failed: %v", filename, err)
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+	return &c, nil
+}
+
+// Validate checks the settings of c.
+func (c *Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.ListenAddress); err != nil {
+		return fmt.Errorf("listen_address: %v", err)
+	}
+	if c.MetricsAddress != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddress); err != nil {
+			return fmt.Errorf("metrics_address: %v", err)
+		}
+	}
+
+	if len(c.Clusters) > 0 && c.ClustersFile != "" {
+		return fmt.Errorf("clusters and clusters_file are exclusive")
+	}
+	if len(c.Clusters) == 0 && c.ClustersFile == "" {
+		if err := validateURL(c.PrometheusURL); err != nil {
+			return fmt.Errorf("prometheus_url: %v", err)
+		}
+	}
+	for name, ds := range c.Clusters {
+		if ds == nil || ds.Url == "" {
+			return fmt.Errorf("cluster %q has no Prometheus url", name)
+		}
+		if err := validateURL(ds.Url); err != nil {
+			return fmt.Errorf("cluster %q: %v", name, err)
+		}
+		if err := ds.Labels.Validate(); err != nil {
+			return fmt.Errorf("cluster %q labels: %v", name, err)
+		}
+	}
+
+	if c.Labels.Namespace == "" || c.Labels.PodName == "" {
+		return fmt.Errorf("labels: the namespace and pod_name labels are required")
+	}
+	if err := c.Labels.Validate(); err != nil {
+		return fmt.Errorf("labels: %v", err)
+	}
+
+	if c.Query.Timeout <= 0 {
+		return fmt.Errorf("query: timeout should be positive")
+	}
+	if c.Query.Concurrency < 1 {
+		return fmt.Errorf("query: concurrency should be at least 1")
+	}
+	if c.Query.MaxDataPoints < 1 {
+		return fmt.Errorf("query: max_data_points should be at least 1")
+	}
+	if c.Cache.LabelTTL < 0 || c.Cache.ResultsSize < 0 || c.Cache.ResultsTTL < 0 || c.Cache.ResultsMaxFreshness < 0 {
+		return fmt.Errorf("cache: negative settings")
+	}
+
+	switch c.Store.Kind {
+	case "memory":
+	case "mysql":
+		if c.Store.DSN == "" {
+			return fmt.Errorf("store: dsn is required by mysql")
+		}
+	default:
+		return fmt.Errorf("store: unknown kind %q", c.Store.Kind)
+	}
+
+	switch c.Auth.Mode {
+	case "none", "token-review":
+	case "token-file":
+		if c.Auth.TokenFile == "" {
+			return fmt.Errorf("auth: token_file is required by the token-file mode")
+		}
+	default:
+		return fmt.Errorf("auth: unknown mode %q", c.Auth.Mode)
+	}
+	switch c.Auth.AuthzMode {
+	case "none", "subject-access-review":
+	case "policy":
+		if c.Auth.PolicyFile == "" {
+			return fmt.Errorf("auth: policy_file is required by the policy mode")
+		}
+	default:
+		return fmt.Errorf("auth: unknown authz_mode %q", c.Auth.AuthzMode)
+	}
+	if c.Auth.Mode == "none" && c.Auth.AuthzMode != "none" {
+		return fmt.Errorf("auth: authorization requires authentication")
+	}
+
+	return nil
+}
+
+// Validate returns an error if the labels are not valid label names, the empty ones
+// are left to the defaults.
+func (l *LabelsConfig) Validate() error {
+	for _, name := range []string{l.Namespace, l.PodName} {
+		if name != "" && !promql.IsLabelName(name) {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	return nil
+}
+
+// Override returns the labels whose non-empty ones are replaced by those of o.
+func (l LabelsConfig) Override(o LabelsConfig) LabelsConfig {
+	if o.Namespace != "" {
+		l.Namespace = o.Namespace
+	}
+	if o.PodName != "" {
+		l.PodName = o.PodName
+	}
+	return l
+}
+
+func validateURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid scheme of url %q", s)
+	}
+	return nil
 }
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, dir, content string) string {
+	filename := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := load(writeConfig(t, dir, `
+listen_address: 127.0.0.1:9000
+clusters:
+  ca:
+    url: http://ca:9090
+    kubernetes:
+      url: https://ca:6443
+labels:
+  namespace: namespace
+  pod_name: pod
+query:
+  timeout: 1m
+store:
+  kind: mysql
+  dsn: user:password@tcp(127.0.0.1:3306)/prometheus
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.ListenAddress != "127.0.0.1:9000" || c.Query.Timeout != time.Minute || c.Store.Kind != "mysql" {
+		t.Errorf("unexpected settings of the config file: %+v", c)
+	}
+	if !reflect.DeepEqual(c.Clusters, map[string]*DataSource{
+		"ca": {Url: "http://ca:9090", Kubernetes: KubernetesSource{Url: "https://ca:6443"}},
+	}) {
+		t.Errorf("unexpected clusters: %v", c.Clusters)
+	}
+	if c.Labels != (LabelsConfig{Namespace: "namespace", PodName: "pod"}) {
+		t.Errorf("unexpected labels: %+v", c.Labels)
+	}
+	// The settings absent from the config file are those of the flags.
+	if c.Query.Concurrency != flags.Query.Concurrency || c.MetricsAddress != flags.MetricsAddress {
+		t.Errorf("expected the settings of the flags to be kept, got %+v", c)
+	}
+
+	if c, err := load(""); err != nil || !reflect.DeepEqual(*c, flags) {
+		t.Errorf("expected the config of the flags without config file, got %+v, %v", c, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		content string
+		err     string
+	}{
+		{"listen_address: 8080\n", "listen_address"},
+		{"unknown: 1\n", "field unknown not found"},
+		{"clusters:\n  ca: {}\n", `cluster "ca" has no Prometheus url`},
+		{"clusters:\n  ca:\n    url: ftp://ca\n", "invalid scheme"},
+		{"clusters:\n  ca:\n    url: http://ca:9090\nclusters_file: clusters.yaml\n", "exclusive"},
+		{"labels:\n  pod_name: \"\"\n", "labels"},
+		{"labels:\n  pod_name: pod-name\n", "invalid label name"},
+		{"clusters:\n  ca:\n    url: http://ca:9090\n    labels:\n      namespace: 1ns\n", "invalid label name"},
+		{"query:\n  timeout: 0s\n", "timeout"},
+		{"query:\n  concurrency: 0\n", "concurrency"},
+		{"cache:\n  results_ttl: -1s\n", "negative"},
+		{"store:\n  kind: mysql\n", "dsn"},
+		{"store:\n  kind: redis\n", "unknown kind"},
+		{"auth:\n  mode: token-file\n", "token_file"},
+		{"auth:\n  mode: token-review\n  authz_mode: policy\n", "policy_file"},
+		{"auth:\n  authz_mode: subject-access-review\n", "requires authentication"},
+	} {
+		_, err := load(writeConfig(t, dir, c.content))
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("config %q: expected error containing %q, got %v", c.content, c.err, err)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	defer Set(Get())
+
+	var reloaded [][2]*Config
+	OnReload(func(old, new *Config) {
+		reloaded = append(reloaded, [2]*Config{old, new})
+	})
+
+	old := Get()
+	c := *old
+	c.Query.Timeout = time.Hour
+	Set(&c)
+
+	if Get().Query.Timeout != time.Hour {
+		t.Errorf("expected the new config, got %+v", Get())
+	}
+	if len(reloaded) != 1 || reloaded[0][0] != old || reloaded[0][1] != &c {
+		t.Errorf("expected the functions to be called with the old and new config, got %v", reloaded)
+	}
+
+	c.Store.Kind = "mysql"
+	c.RateLimitsFile = "limits.yaml"
+	if changed := restartRequired(old, &c); !reflect.DeepEqual(changed, []string{"store", "rate_limits_file"}) {
+		t.Errorf("unexpected settings which require restart: %v", changed)
+	}
+}
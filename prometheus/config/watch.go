@@ -0,0 +1,84 @@
+package config
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+)
+
+// watchInterval is the interval to check whether the config file is modified.
+const watchInterval = 10 * time.Second
+
+// Watch reloads the config file on SIGHUP or once its modification time changes,
+// until stop is closed. The current Config is kept if the new one is invalid.
+func Watch(stop <-chan struct{}) {
+	if ConfigFile == "" {
+		return
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	modTime := fileModTime(ConfigFile)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+			logs.Info("SIGHUP received, reloading config file %s", ConfigFile)
+		case <-ticker.C:
+			t := fileModTime(ConfigFile)
+			if t.Equal(modTime) {
+				continue
+			}
+			modTime = t
+		}
+
+		old := Get()
+		if err := Load(); err != nil {
+			logs.Error("Reload config file %s failed: %v", ConfigFile, err)
+			continue
+		}
+		logs.Info("config file %s reloaded", ConfigFile)
+		if changed := restartRequired(old, Get()); len(changed) > 0 {
+			logs.Warn("%v changed in config file %s, which take effect on restart", changed, ConfigFile)
+		}
+	}
+}
+
+func fileModTime(filename string) time.Time {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// restartRequired returns the settings changed from old to new which take effect on
+// restart.
+func restartRequired(old, new *Config) []string {
+	var changed []string
+	if old.ListenAddress != new.ListenAddress {
+		changed = append(changed, "listen_address")
+	}
+	if old.MetricsAddress != new.MetricsAddress {
+		changed = append(changed, "metrics_address")
+	}
+	if old.Store != new.Store {
+		changed = append(changed, "store")
+	}
+	if old.Cache.LabelTTL != new.Cache.LabelTTL || old.Cache.ResultsSize != new.Cache.ResultsSize {
+		changed = append(changed, "cache")
+	}
+	if old.RateLimitsFile != new.RateLimitsFile {
+		changed = append(changed, "rate_limits_file")
+	}
+	return changed
+}
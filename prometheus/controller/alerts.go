@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/YaoZengzeng/practice/prometheus/config"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/prometheus/common/model"
+)
+
+// alert is an active alert of a namespace or pod.
+type alert struct {
+	Name        string         `json:"name"`
+	State       AlertState     `json:"state"`
+	ActiveAt    time.Time      `json:"activeAt"`
+	Value       string         `json:"value"`
+	Labels      model.LabelSet `json:"labels"`
+	Annotations model.LabelSet `json:"annotations"`
+}
+
+func newAlert(a *Alert) *alert {
+	return &alert{
+		Name:        string(a.Labels[model.AlertNameLabel]),
+		State:       a.State,
+		ActiveAt:    a.ActiveAt,
+		Value:       a.Value,
+		Labels:      a.Labels,
+		Annotations: a.Annotations,
+	}
+}
+
+// alertingRule is an alerting rule with its active alerts of a namespace or pod.
+type alertingRule struct {
+	Name     string     `json:"name"`
+	Group    string     `json:"group"`
+	Query    string     `json:"query"`
+	Duration float64    `json:"duration"`
+	Health   RuleHealth `json:"health"`
+	Alerts   []*alert   `json:"alerts"`
+}
+
+// matchAlert returns true if the alert is of namespace, and of pod if it's not empty.
+// names are the names of the labels which identify the pods.
+func matchAlert(labels model.LabelSet, names config.LabelsConfig, namespace, pod string) bool {
+	if string(labels[model.LabelName(names.Namespace)]) != namespace {
+		return false
+	}
+	return pod == "" || string(labels[model.LabelName(names.PodName)]) == pod
+}
+
+// sortAlerts sorts the firing alerts before the pending ones, the earliest first.
+func sortAlerts(alerts []*alert) {
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].State != alerts[j].State {
+			return alerts[i].State == AlertStateFiring
+		}
+		return alerts[i].ActiveAt.Before(alerts[j].ActiveAt)
+	})
+}
+
+func filterAlerts(result AlertsResult, names config.LabelsConfig, namespace, pod string) []*alert {
+	alerts := []*alert{}
+	for i := range result.Alerts {
+		if matchAlert(result.Alerts[i].Labels, names, namespace, pod) {
+			alerts = append(alerts, newAlert(&result.Alerts[i]))
+		}
+	}
+	sortAlerts(alerts)
+
+	return alerts
+}
+
+// filterAlertingRules returns the alerting rules which have active alerts of namespace
+// or pod, only the matched alerts are kept.
+func filterAlertingRules(result RulesResult, names config.LabelsConfig, namespace, pod string) []*alertingRule {
+	rules := []*alertingRule{}
+	for _, group := range result.Groups {
+		for _, r := range group.Rules {
+			ar, ok := r.(AlertingRule)
+			if !ok {
+				continue
+			}
+
+			rule := &alertingRule{
+				Name:     ar.Name,
+				Group:    group.Name,
+				Query:    ar.Query,
+				Duration: ar.Duration,
+				Health:   ar.Health,
+			}
+			for _, a := range ar.Alerts {
+				if matchAlert(a.Labels, names, namespace, pod) {
+					rule.Alerts = append(rule.Alerts, newAlert(a))
+				}
+			}
+			if len(rule.Alerts) != 0 {
+				sortAlerts(rule.Alerts)
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	return rules
+}
+
+func (p *PrometheusController) QueryAlerts() *queryResult {
+	cluster := p.GetString(":cluster")
+	namespace := p.GetString(":namespace")
+	pod := p.GetString(":pod")
+	logs.Info("cluster: %s, namespace: %s, pod: %s", cluster, namespace, pod)
+
+	client, err := p.getClusterClient(cluster)
+	if err != nil {
+		return &queryResult{
+			Status:    statusError,
+			ErrorType: classifyError(err),
+			Error:     fmt.Sprintf("Get Prometheus client failed: %v", err),
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(p.Ctx.Request.Context(), config.Get().Query.Timeout)
+	defer cancel()
+
+	result, err := client.Alerts(ctx)
+	if err != nil {
+		return &queryResult{
+			Status:    statusError,
+			ErrorType: classifyError(err),
+			Error:     fmt.Sprintf("Get alerts failed: %v", err),
+		}
+	}
+
+	return &queryResult{
+		Status: statusSuccess,
+		Data:   filterAlerts(result, p.podLabels(cluster), namespace, pod),
+	}
+}
+
+func (p *PrometheusController) Alerts() {
+	p.writeResult(p.QueryAlerts())
+}
+
+func (p *PrometheusController) QueryAlertingRules() *queryResult {
+	cluster := p.GetString(":cluster")
+	namespace := p.GetString(":namespace")
+	pod := p.GetString(":pod")
+	logs.Info("cluster: %s, namespace: %s, pod: %s", cluster, namespace, pod)
+
+	client, err := p.getClusterClient(cluster)
+	if err != nil {
+		return &queryResult{
+			Status:    statusError,
+			ErrorType: classifyError(err),
+			Error:     fmt.Sprintf("Get Prometheus client failed: %v", err),
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(p.Ctx.Request.Context(), config.Get().Query.Timeout)
+	defer cancel()
+
+	result, err := client.Rules(ctx)
+	if err != nil {
+		return &queryResult{
+			Status:    statusError,
+			ErrorType: classifyError(err),
+			Error:     fmt.Sprintf("Get rules failed: %v", err),
+		}
+	}
+
+	return &queryResult{
+		Status: statusSuccess,
+		Data:   filterAlertingRules(result, p.podLabels(cluster), namespace, pod),
+	}
+}
+
+func (p *PrometheusController) AlertingRules() {
+	p.writeResult(p.QueryAlertingRules())
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/YaoZengzeng/practice/prometheus/config"
+)
+
+const testRulesResult = `{
+	"groups": [{
+		"name": "pods",
+		"file": "/etc/prometheus/rules/pods.yaml",
+		"interval": 30,
+		"rules": [{
+			"type": "recording",
+			"name": "pod:up",
+			"query": "up{kubernetes_pod_name!=\"\"}",
+			"health": "ok"
+		}, {
+			"type": "alerting",
+			"name": "PodDown",
+			"query": "up == 0",
+			"duration": 300,
+			"health": "ok",
+			"alerts": [{
+				"labels": {"alertname": "PodDown", "kubernetes_namespace": "na", "kubernetes_pod_name": "pa"},
+				"annotations": {"summary": "Pod is down"},
+				"state": "pending",
+				"activeAt": "2019-04-23T10:00:00Z",
+				"value": "0e+00"
+			}, {
+				"labels": {"alertname": "PodDown", "kubernetes_namespace": "na", "kubernetes_pod_name": "pb"},
+				"state": "firing",
+				"activeAt": "2019-04-23T10:05:00Z",
+				"value": "0e+00"
+			}, {
+				"labels": {"alertname": "PodDown", "kubernetes_namespace": "nb", "kubernetes_pod_name": "pa"},
+				"state": "firing",
+				"activeAt": "2019-04-23T09:00:00Z",
+				"value": "0e+00"
+			}]
+		}]
+	}]
+}`
+
+func TestFilterAlertingRules(t *testing.T) {
+	var result RulesResult
+	if err := json.Unmarshal([]byte(testRulesResult), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := filterAlertingRules(result, config.Get().Labels, "na", "")
+	if len(rules) != 1 || rules[0].Name != "PodDown" || rules[0].Group != "pods" {
+		t.Fatalf("expected alerting rule PodDown, got %+v", rules)
+	}
+	alerts := rules[0].Alerts
+	if len(alerts) != 2 || alerts[0].State != AlertStateFiring || alerts[1].Annotations["summary"] != "Pod is down" {
+		t.Errorf("expected the firing alert before the pending one, got %+v", alerts)
+	}
+
+	if rules := filterAlertingRules(result, config.Get().Labels, "na", "pa"); len(rules) != 1 || len(rules[0].Alerts) != 1 {
+		t.Errorf("expected 1 alert of pod pa, got %+v", rules)
+	}
+	if rules := filterAlertingRules(result, config.Get().Labels, "nc", ""); len(rules) != 0 {
+		t.Errorf("expected no alerting rules of namespace nc, got %+v", rules)
+	}
+}
+
+func TestFilterAlerts(t *testing.T) {
+	var result RulesResult
+	if err := json.Unmarshal([]byte(testRulesResult), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	var alerts AlertsResult
+	for _, a := range result.Groups[0].Rules[1].(AlertingRule).Alerts {
+		alerts.Alerts = append(alerts.Alerts, *a)
+	}
+
+	filtered := filterAlerts(alerts, config.Get().Labels, "nb", "pa")
+	if len(filtered) != 1 || filtered[0].Name != "PodDown" || filtered[0].ActiveAt.Hour() != 9 {
+		t.Errorf("expected 1 alert of pod nb/pa, got %+v", filtered)
+	}
+
+	// The clusters scraped by prometheus-operator use other labels.
+	operator := config.LabelsConfig{Namespace: "namespace", PodName: "pod"}
+	if filtered := filterAlerts(alerts, operator, "nb", "pa"); len(filtered) != 0 {
+		t.Errorf("expected no alerts with labels namespace and pod, got %+v", filtered)
+	}
+}
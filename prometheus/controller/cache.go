@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"sync"
+	"time"
+)
+
+// ttlCache is a cache whose entries expire after ttl. When it is full, the expired
+// entries are evicted, and then the oldest ones if it is still full.
+type ttlCache struct {
+	sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]*ttlEntry
+}
+
+type ttlEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func newTTLCache(ttl time.Duration, maxEntries int) *ttlCache {
+	return &ttlCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*ttlEntry),
+	}
+}
+
+func (c *ttlCache) get(key string) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	return e.value, true
+}
+
+func (c *ttlCache) set(key string, value interface{}) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+
+	c.entries[key] = &ttlEntry{
+		value:   value,
+		expires: now.Add(c.ttl),
+	}
+}
+
+// evict removes the expired entries, or the oldest one if none is expired.
+func (c *ttlCache) evict(now time.Time) {
+	var oldest string
+	var oldestExpires time.Time
+	for key, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, key)
+			continue
+		}
+		if oldest == "" || e.expires.Before(oldestExpires) {
+			oldest, oldestExpires = key, e.expires
+		}
+	}
+
+	if len(c.entries) >= c.maxEntries {
+		delete(c.entries, oldest)
+	}
+}
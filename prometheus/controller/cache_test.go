@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTTLCache(t *testing.T) {
+	c := newTTLCache(50*time.Millisecond, 2)
+
+	c.set("a", 1)
+	if v, ok := c.get("a"); !ok || v.(int) != 1 {
+		t.Errorf("get(a) = %v, %v, want 1, true", v, ok)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := c.get("a"); ok {
+		t.Errorf("a should be expired")
+	}
+
+	for i := 0; i < 3; i++ {
+		c.set(fmt.Sprintf("k%d", i), i)
+		time.Sleep(time.Millisecond)
+	}
+	if len(c.entries) != 2 {
+		t.Errorf("cache has %d entries, want 2", len(c.entries))
+	}
+	if _, ok := c.get("k0"); ok {
+		t.Errorf("the oldest entry should be evicted")
+	}
+	if _, ok := c.get("k2"); !ok {
+		t.Errorf("the newest entry should be kept")
+	}
+}
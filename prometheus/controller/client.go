@@ -0,0 +1,222 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/YaoZengzeng/practice/prometheus/config"
+
+	"github.com/astaxie/beego/logs"
+	api "github.com/prometheus/client_golang/api"
+)
+
+// ClientPool caches the Prometheus API clients keyed by DataSource, so the underlying
+// connections could be reused by the following requests.
+type ClientPool struct {
+	sync.Mutex
+	clients map[DataSource]*pooledClient
+}
+
+type pooledClient struct {
+	api       API
+	transport *http.Transport
+}
+
+func NewClientPool() *ClientPool {
+	return &ClientPool{
+		clients: make(map[DataSource]*pooledClient),
+	}
+}
+
+// Get returns the cached client of ds, a new one is created if there is none.
+func (c *ClientPool) Get(ds *DataSource) (API, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if pc, ok := c.clients[*ds]; ok {
+		return pc.api, nil
+	}
+
+	transport, err := newTransport(ds)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := api.Config{
+		Address:      ds.Url,
+		RoundTripper: transport,
+	}
+	if ds.Token != "" {
+		cfg.RoundTripper = &bearerAuthRoundTripper{
+			token: ds.Token,
+			rt:    transport,
+		}
+	}
+
+	client, err := api.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	pc := &pooledClient{
+		api:       NewAPI(client),
+		transport: transport,
+	}
+	c.clients[*ds] = pc
+
+	return pc.api, nil
+}
+
+// Invalidate removes the client of ds from the pool and closes its idle connections.
+func (c *ClientPool) Invalidate(ds DataSource) {
+	c.Lock()
+	pc, ok := c.clients[ds]
+	delete(c.clients, ds)
+	c.Unlock()
+
+	if ok {
+		pc.transport.CloseIdleConnections()
+		logs.Info("client of Prometheus %s invalidated", ds.Url)
+	}
+}
+
+func newTransport(ds *DataSource) (*http.Transport, error) {
+	tlsConfig, err := newTLSConfig(ds)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   config.Get().Connection.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   config.Get().Connection.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: config.Get().Connection.ResponseHeaderTimeout,
+		IdleConnTimeout:       config.Get().Connection.IdleConnTimeout,
+		MaxIdleConnsPerHost:   config.Get().Connection.MaxIdleConnsPerHost,
+	}, nil
+}
+
+func newTLSConfig(ds *DataSource) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: ds.InsecureSkipVerify,
+	}
+
+	if ds.CAFile != "" {
+		b, err := ioutil.ReadFile(ds.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file failed: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate found in CA file %s", ds.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (ds.CertFile == "") != (ds.KeyFile == "") {
+		return nil, fmt.Errorf("cert file and key file should be specified together")
+	}
+	if ds.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(ds.CertFile, ds.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate failed: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// bearerAuthRoundTripper sets the Authorization header of every request with token.
+type bearerAuthRoundTripper struct {
+	token string
+	rt    http.RoundTripper
+}
+
+func (b *bearerAuthRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(req.Header.Get("Authorization")) != 0 {
+		return b.rt.RoundTrip(req)
+	}
+
+	// RoundTrip should not modify the request, so clone it.
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+	r.Header.Set("Authorization", "Bearer "+b.token)
+
+	return b.rt.RoundTrip(r)
+}
+
+// tokenFileTTL is how long the token read from a file is used before the file is
+// reread, so the rotated tokens are picked up.
+const tokenFileTTL = time.Minute
+
+// tokenFileRoundTripper sets the Authorization header of every request with the token
+// in file like bearerAuthRoundTripper. If the file fails to be reread, the last read
+// token is used until it succeeds.
+type tokenFileRoundTripper struct {
+	file string
+	rt   http.RoundTripper
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (t *tokenFileRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(req.Header.Get("Authorization")) != 0 {
+		return t.rt.RoundTrip(req)
+	}
+
+	token, err := t.getToken()
+	if err != nil {
+		return nil, err
+	}
+	b := &bearerAuthRoundTripper{
+		token: token,
+		rt:    t.rt,
+	}
+	return b.RoundTrip(req)
+}
+
+// getToken returns the cached token, the file is reread once the token expires.
+func (t *tokenFileRoundTripper) getToken() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if t.token != "" && now.Before(t.expires) {
+		return t.token, nil
+	}
+
+	b, err := ioutil.ReadFile(t.file)
+	if err == nil && len(bytes.TrimSpace(b)) == 0 {
+		err = fmt.Errorf("%s is empty", t.file)
+	}
+	if err != nil {
+		if t.token == "" {
+			return "", fmt.Errorf("read token failed: %v", err)
+		}
+		logs.Warn("Reread token failed, the last read one is used: %v", err)
+		return t.token, nil
+	}
+
+	t.token = string(bytes.TrimSpace(b))
+	t.expires = now.Add(tokenFileTTL)
+
+	return t.token, nil
+}
@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClientPool(t *testing.T) {
+	pool := NewClientPool()
+	ds := &DataSource{Url: "http://ca:9090"}
+
+	c1, err := pool.Get(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := pool.Get(&DataSource{Url: "http://ca:9090"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 != c2 {
+		t.Errorf("clients of the same DataSource should be reused")
+	}
+
+	c3, err := pool.Get(&DataSource{Url: "http://ca:9090", Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 == c3 {
+		t.Errorf("clients of different DataSources should not be shared")
+	}
+
+	pool.Invalidate(*ds)
+	c4, err := pool.Get(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 == c4 {
+		t.Errorf("client should be recreated after invalidated")
+	}
+
+	if _, err := pool.Get(&DataSource{Url: "https://ca:9090", CertFile: "cert.pem"}); err == nil {
+		t.Errorf("cert file without key file should be rejected")
+	}
+}
+
+func TestBearerAuthRoundTripper(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &bearerAuthRoundTripper{
+			token: "secret",
+			rt:    http.DefaultTransport,
+		},
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got != "Bearer secret" {
+		t.Errorf("Authorization = %q, want %q", got, "Bearer secret")
+	}
+}
+
+func TestTokenFileRoundTripper(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "token")
+
+	rt := &tokenFileRoundTripper{
+		file: file,
+		rt:   http.DefaultTransport,
+	}
+	client := &http.Client{Transport: rt}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Errorf("request without the token file should fail")
+	}
+
+	for _, c := range []struct {
+		token  string
+		expire bool
+		want   string
+	}{
+		{"first\n", false, "Bearer first"},
+		// The token is cached until it expires.
+		{"second\n", false, "Bearer first"},
+		{"second\n", true, "Bearer second"},
+		// The last read token is used if the file fails to be reread.
+		{"", true, "Bearer second"},
+	} {
+		if c.token != "" {
+			if err := ioutil.WriteFile(file, []byte(c.token), 0600); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			os.Remove(file)
+		}
+		if c.expire {
+			rt.expires = time.Now().Add(-time.Second)
+		}
+
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if got != c.want {
+			t.Errorf("Authorization = %q, want %q", got, c.want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/YaoZengzeng/practice/prometheus/config"
+
+	"github.com/astaxie/beego/logs"
+	"gopkg.in/yaml.v2"
+)
+
+var errClusterNotFound = errors.New("cluster not found")
+
+// unknownCluster is the cluster label of the metrics of the clusters which are not
+// listed, any name is accepted then so it can't be used as a label value.
+const unknownCluster = "unknown"
+
+// clustersCheckInterval is the interval to check whether the clusters file is modified.
+const clustersCheckInterval = time.Second
+
+// ClusterRegistry maps the name of a cluster to the DataSource of its Prometheus.
+// The clusters are listed in the config file, or in the clusters file which looks like:
+//
+//	ca:
+//	  url: http://10.32.0.2:9090
+//	cb:
+//	  url: https://prometheus.cb.example.com
+//	  token: xxxxxx
+//	  kubernetes:
+//	    url: https://kubernetes.cb.example.com:6443
+//	    token: xxxxxx
+//	  labels:
+//	    namespace: namespace
+//	    pod_name: pod
+//
+// The file is checked at most once every clustersCheckInterval and reloaded once its
+// modification time changes, so clusters could be added or removed without restarting.
+// If the reload fails, e.g. after a bad edit, the last loaded clusters are served until
+// the file is fixed.
+type ClusterRegistry struct {
+	sync.RWMutex
+	loaded   string
+	modTime  time.Time
+	checked  time.Time
+	clusters map[string]*DataSource
+
+	// onChange is called with the old DataSource of every cluster which is
+	// modified or removed by a reload.
+	onChange []func(old DataSource)
+}
+
+func NewClusterRegistry() *ClusterRegistry {
+	return &ClusterRegistry{}
+}
+
+// OnChange registers f to be called when the DataSource of a cluster is modified or
+// removed, so the resources bound to the old one could be released.
+func (c *ClusterRegistry) OnChange(f func(old DataSource)) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.onChange = append(c.onChange, f)
+}
+
+// Get returns the DataSource of cluster, errClusterNotFound is returned if the
+// cluster is not in the config file or the clusters file. If neither lists the
+// clusters, all of them share the Prometheus of prometheus_url.
+func (c *ClusterRegistry) Get(cluster string) (*DataSource, error) {
+	cfg := config.Get()
+	if cfg.ClustersFile == "" {
+		if len(cfg.Clusters) == 0 {
+			return &DataSource{Url: cfg.PrometheusURL}, nil
+		}
+
+		ds, ok := cfg.Clusters[cluster]
+		if !ok {
+			return nil, errClusterNotFound
+		}
+		return ds, nil
+	}
+
+	if err := c.reload(cfg.ClustersFile); err != nil {
+		return nil, err
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+
+	ds, ok := c.clusters[cluster]
+	if !ok {
+		return nil, errClusterNotFound
+	}
+
+	return ds, nil
+}
+
+// metricsLabel returns the value of the cluster label of the metrics of cluster,
+// which is only the name of cluster if the clusters are listed.
+func (c *ClusterRegistry) metricsLabel(cluster string) string {
+	cfg := config.Get()
+	if cfg.ClustersFile == "" && len(cfg.Clusters) == 0 {
+		return unknownCluster
+	}
+	return cluster
+}
+
+// ConfigReloaded releases the resources bound to the clusters of old which are
+// modified or removed in new, all of them are released if the connection settings
+// are changed.
+func (c *ClusterRegistry) ConfigReloaded(old, new *config.Config) {
+	oldSources, newSources := c.sources(old), c.sources(new)
+	reconnect := old.Connection != new.Connection
+
+	c.RLock()
+	onChange := c.onChange
+	c.RUnlock()
+
+	for name, ds := range oldSources {
+		if nds, ok := newSources[name]; ok && *nds == *ds && !reconnect {
+			continue
+		}
+		for _, f := range onChange {
+			f(*ds)
+		}
+	}
+}
+
+// sources returns the DataSources of the clusters of cfg. Those of the clusters file
+// are the loaded ones, and the shared Prometheus is named by the empty string.
+func (c *ClusterRegistry) sources(cfg *config.Config) map[string]*DataSource {
+	switch {
+	case cfg.ClustersFile != "":
+		c.RLock()
+		defer c.RUnlock()
+
+		if c.loaded != cfg.ClustersFile {
+			return nil
+		}
+		return c.clusters
+	case len(cfg.Clusters) > 0:
+		return cfg.Clusters
+	default:
+		return map[string]*DataSource{"": {Url: cfg.PrometheusURL}}
+	}
+}
+
+// reload reads the clusters file again if it was modified since last load, which is
+// checked at most once every clustersCheckInterval once the file is loaded. If the
+// file fails to load, the clusters loaded last time are kept and the file is read
+// again once it's modified.
+func (c *ClusterRegistry) reload(filename string) error {
+	now := time.Now()
+	c.Lock()
+	if c.loaded == filename && now.Sub(c.checked) < clustersCheckInterval {
+		c.Unlock()
+		return nil
+	}
+	c.checked = now
+	c.Unlock()
+
+	var modTime time.Time
+	info, statErr := os.Stat(filename)
+	if statErr == nil {
+		modTime = info.ModTime()
+	}
+
+	c.RLock()
+	fresh := c.loaded == filename && c.modTime.Equal(modTime)
+	c.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	clusters, err := readClusters(filename)
+	if statErr != nil {
+		err = fmt.Errorf("stat clusters file failed: %v", statErr)
+	}
+	if err != nil {
+		c.Lock()
+		defer c.Unlock()
+
+		// Nothing to serve if the file has never been loaded.
+		if c.loaded != filename {
+			return err
+		}
+		c.modTime = modTime
+		logs.Error("Reload clusters file %s failed, the last loaded clusters are kept: %v", filename, err)
+		return nil
+	}
+
+	c.Lock()
+	old := c.clusters
+	c.loaded = filename
+	c.modTime = modTime
+	c.clusters = clusters
+	onChange := c.onChange
+	c.Unlock()
+
+	for name, ds := range old {
+		if nds, ok := clusters[name]; ok && *nds == *ds {
+			continue
+		}
+		for _, f := range onChange {
+			f(*ds)
+		}
+	}
+
+	logs.Info("clusters file %s reloaded, %d clusters", filename, len(clusters))
+
+	return nil
+}
+
+// readClusters reads and validates the clusters file.
+func readClusters(filename string) (map[string]*DataSource, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("read clusters file failed: %v", err)
+	}
+
+	clusters := make(map[string]*DataSource)
+	if err := yaml.UnmarshalStrict(b, &clusters); err != nil {
+		return nil, fmt.Errorf("parse clusters file failed: %v", err)
+	}
+
+	for name, ds := range clusters {
+		if ds == nil || ds.Url == "" {
+			return nil, fmt.Errorf("cluster %q has no Prometheus url", name)
+		}
+		if err := ds.Labels.Validate(); err != nil {
+			return nil, fmt.Errorf("cluster %q labels: %v", name, err)
+		}
+	}
+
+	return clusters, nil
+}
@@ -0,0 +1,215 @@
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/YaoZengzeng/practice/prometheus/config"
+	"github.com/YaoZengzeng/practice/prometheus/promql"
+)
+
+func TestClusterRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clusters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "clusters.yaml")
+	if err := ioutil.WriteFile(filename, []byte("ca:\n  url: http://ca:9090\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer config.Set(config.Get())
+	cfg := *config.Get()
+	cfg.ClustersFile = filename
+	config.Set(&cfg)
+
+	c := NewClusterRegistry()
+	var changed []DataSource
+	c.OnChange(func(old DataSource) {
+		changed = append(changed, old)
+	})
+
+	ds, err := c.Get("ca")
+	if err != nil {
+		t.Fatalf("Get(ca) failed: %v", err)
+	}
+	if ds.Url != "http://ca:9090" {
+		t.Errorf("Get(ca).Url = %q, want %q", ds.Url, "http://ca:9090")
+	}
+
+	if _, err := c.Get("cb"); err != errClusterNotFound {
+		t.Errorf("Get(cb) error = %v, want %v", err, errClusterNotFound)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("cb:\n  url: http://cb:9090\n  token: secret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Make sure the modification time changes even on file systems with coarse timestamps.
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filename, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is not checked again within clustersCheckInterval.
+	if _, err := c.Get("ca"); err != nil {
+		t.Errorf("Get(ca) before the check interval elapses failed: %v", err)
+	}
+	c.checked = time.Time{}
+
+	if _, err := c.Get("ca"); err != errClusterNotFound {
+		t.Errorf("Get(ca) after reload error = %v, want %v", err, errClusterNotFound)
+	}
+	if len(changed) != 1 || changed[0].Url != "http://ca:9090" {
+		t.Errorf("changed DataSources = %v, want the one of ca", changed)
+	}
+	ds, err = c.Get("cb")
+	if err != nil {
+		t.Fatalf("Get(cb) after reload failed: %v", err)
+	}
+	if ds.Token != "secret" {
+		t.Errorf("Get(cb).Token = %q, want %q", ds.Token, "secret")
+	}
+
+	// A bad edit keeps the clusters loaded last time.
+	if err := ioutil.WriteFile(filename, []byte("cb: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	if err := os.Chtimes(filename, later, later); err != nil {
+		t.Fatal(err)
+	}
+	c.checked = time.Time{}
+	if ds, err := c.Get("cb"); err != nil || ds.Token != "secret" {
+		t.Errorf("Get(cb) after bad edit = %v, %v, want the last loaded one", ds, err)
+	}
+	if len(changed) != 1 {
+		t.Errorf("changed DataSources after bad edit = %v, want none more", changed)
+	}
+
+	// The file is loaded again once it's fixed.
+	if err := ioutil.WriteFile(filename, []byte("cc:\n  url: http://cc:9090\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	if err := os.Chtimes(filename, later, later); err != nil {
+		t.Fatal(err)
+	}
+	c.checked = time.Time{}
+	if _, err := c.Get("cc"); err != nil {
+		t.Errorf("Get(cc) after fix failed: %v", err)
+	}
+
+	// A bad file is an error if nothing was loaded.
+	if _, err := NewClusterRegistry().Get("cc"); err != nil {
+		t.Errorf("Get(cc) of a new registry failed: %v", err)
+	}
+	if err := ioutil.WriteFile(filename, []byte("cb: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClusterRegistry().Get("cb"); err == nil || err == errClusterNotFound {
+		t.Errorf("Get(cb) of a bad file error = %v, want the parse error", err)
+	}
+}
+
+func TestClusterRegistryConfigReloaded(t *testing.T) {
+	defer config.Set(config.Get())
+	old := *config.Get()
+	old.Clusters = map[string]*DataSource{
+		"ca": {Url: "http://ca:9090"},
+		"cb": {Url: "http://cb:9090"},
+		"cc": {Url: "http://cc:9090"},
+	}
+	config.Set(&old)
+
+	c := NewClusterRegistry()
+	var changed []string
+	c.OnChange(func(old DataSource) {
+		changed = append(changed, old.Url)
+	})
+	config.OnReload(c.ConfigReloaded)
+
+	ds, err := c.Get("cb")
+	if err != nil || ds.Url != "http://cb:9090" {
+		t.Fatalf("Get(cb) = %v, %v, want the one of cb", ds, err)
+	}
+
+	// cb is modified and cc is removed.
+	new := old
+	new.Clusters = map[string]*DataSource{
+		"ca": {Url: "http://ca:9090"},
+		"cb": {Url: "http://cb:9090", Token: "secret"},
+	}
+	config.Set(&new)
+
+	sort.Strings(changed)
+	if !reflect.DeepEqual(changed, []string{"http://cb:9090", "http://cc:9090"}) {
+		t.Errorf("changed DataSources = %v, want those of cb and cc", changed)
+	}
+	if _, err := c.Get("cc"); err != errClusterNotFound {
+		t.Errorf("Get(cc) after reload error = %v, want %v", err, errClusterNotFound)
+	}
+
+	// All the clients reconnect with the new connection settings.
+	changed = nil
+	reconnect := new
+	reconnect.Connection.DialTimeout++
+	config.Set(&reconnect)
+	if len(changed) != 2 {
+		t.Errorf("changed DataSources = %v, want all of them", changed)
+	}
+}
+
+func TestPodLabels(t *testing.T) {
+	defer config.Set(config.Get())
+	cfg := *config.Get()
+	cfg.Clusters = map[string]*DataSource{
+		"ca": {Url: "http://ca:9090"},
+		"cb": {Url: "http://cb:9090", Labels: config.LabelsConfig{Namespace: "namespace", PodName: "pod"}},
+	}
+	config.Set(&cfg)
+
+	p := &PrometheusController{Clusters: NewClusterRegistry()}
+	for _, c := range []struct {
+		cluster string
+		want    string
+	}{
+		{"ca", `up{kubernetes_namespace="default", kubernetes_pod_name="foo\"} or vector(1"}`},
+		{"cb", `up{namespace="default", pod="foo\"} or vector(1"}`},
+		// The unknown clusters fail later, when their clients are got.
+		{"cc", `up{kubernetes_namespace="default", kubernetes_pod_name="foo\"} or vector(1"}`},
+	} {
+		matchers := podMatchers(p.podLabels(c.cluster), "default", `foo"} or vector(1`)
+		if got := promql.NewSelector("up", matchers...).String(); got != c.want {
+			t.Errorf("selector of cluster %s = %s, want %s", c.cluster, got, c.want)
+		}
+	}
+}
+
+func TestClusterRegistryMetricsLabel(t *testing.T) {
+	defer config.Set(config.Get())
+	c := NewClusterRegistry()
+
+	shared := *config.Get()
+	shared.ClustersFile = ""
+	shared.Clusters = nil
+	config.Set(&shared)
+	for _, cluster := range []string{"ca", "cb"} {
+		if got := c.metricsLabel(cluster); got != unknownCluster {
+			t.Errorf("label of cluster %s sharing a Prometheus = %q, want %q", cluster, got, unknownCluster)
+		}
+	}
+
+	listed := shared
+	listed.Clusters = map[string]*DataSource{"ca": {Url: "http://ca:9090"}}
+	config.Set(&listed)
+	if got := c.metricsLabel("ca"); got != "ca" {
+		t.Errorf("label of listed cluster ca = %q, want %q", got, "ca")
+	}
+}
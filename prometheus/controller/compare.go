@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// seriesTag tells whether the series is of the requested range or the offset one.
+type seriesTag string
+
+const (
+	tagCurrent  seriesTag = "current"
+	tagBaseline seriesTag = "baseline"
+
+	// tagLabel is added to the series of MonitorPod in compare mode.
+	tagLabel = "tag"
+)
+
+// parseOffset returns the offset of the baseline range, zero if not comparing. The
+// offset parameter is a duration, compare could also be day or week.
+func parseOffset(r *http.Request) (time.Duration, error) {
+	s := r.FormValue("offset")
+	if s == "" {
+		s = r.FormValue("compare")
+	}
+
+	switch s {
+	case "":
+		return 0, nil
+	case "day":
+		return 24 * time.Hour, nil
+	case "week":
+		return 7 * 24 * time.Hour, nil
+	}
+
+	offset, err := parseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("Parse offset failed: %v", err)
+	}
+	if offset <= 0 {
+		return 0, fmt.Errorf("Offset should be positive")
+	}
+
+	return offset, nil
+}
+
+type taggedRange struct {
+	tag seriesTag
+	apiv1.Range
+}
+
+func shiftRange(r apiv1.Range, offset time.Duration) apiv1.Range {
+	return apiv1.Range{
+		Start: r.Start.Add(-offset),
+		End:   r.End.Add(-offset),
+		Step:  r.Step,
+	}
+}
+
+// shiftMatrix moves the samples of the baseline range forward onto the current range.
+func shiftMatrix(matrix model.Matrix, offset time.Duration) {
+	ms := model.Time(offset / time.Millisecond)
+	for _, ss := range matrix {
+		for i := range ss.Values {
+			ss.Values[i].Timestamp += ms
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+func TestParseOffset(t *testing.T) {
+	tc := []struct {
+		url    string
+		offset time.Duration
+		err    bool
+	}{
+		{"/", 0, false},
+		{"/?offset=1h", time.Hour, false},
+		{"/?offset=86400", 24 * time.Hour, false},
+		{"/?compare=week", 7 * 24 * time.Hour, false},
+		{"/?compare=1d", 24 * time.Hour, false},
+		{"/?offset=-1h", 0, true},
+		{"/?compare=month", 0, true},
+	}
+
+	for _, c := range tc {
+		offset, err := parseOffset(httptest.NewRequest("GET", c.url, nil))
+		if (err != nil) != c.err || offset != c.offset {
+			t.Errorf("%s: expected offset %v, got %v, error: %v", c.url, c.offset, offset, err)
+		}
+	}
+}
+
+func TestShiftMatrix(t *testing.T) {
+	now := time.Unix(1556018614, 0)
+	r := apiv1.Range{Start: now.Add(-time.Hour), End: now, Step: time.Minute}
+
+	baseline := shiftRange(r, 24*time.Hour)
+	if !baseline.End.Equal(now.Add(-24*time.Hour)) || baseline.Step != r.Step {
+		t.Fatalf("unexpected baseline range %+v", baseline)
+	}
+
+	matrix := model.Matrix{
+		&model.SampleStream{Values: []model.SamplePair{{Timestamp: model.TimeFromUnixNano(baseline.Start.UnixNano()), Value: 1}}},
+	}
+	shiftMatrix(matrix, 24*time.Hour)
+	if ts := matrix[0].Values[0].Timestamp.Time(); !ts.Equal(r.Start) {
+		t.Errorf("expected baseline sample shifted to %v, got %v", r.Start, ts)
+	}
+}
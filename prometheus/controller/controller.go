@@ -6,27 +6,35 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"regexp"
 	"strconv"
 	"time"
 
+	"github.com/YaoZengzeng/practice/prometheus/config"
+	"github.com/YaoZengzeng/practice/prometheus/promql"
+
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/logs"
-	api "github.com/prometheus/client_golang/api"
 	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
-	"github.com/YaoZengzeng/practice/prometheus/config"
 )
 
 type PrometheusController struct {
 	beego.Controller
 
-	Store Store
+	Store        Store
+	Clusters     *ClusterRegistry
+	Clients      *ClientPool
+	LabelCache   *ttlCache
+	ResultsCache *ResultsCache
+	KubeClients  *KubeClientPool
+	RuleClients  *RuleClientPool
+	PodQueries   *PodQueries
 }
 
-type DataSource struct {
-	Url   string
-	Token string
-}
+// DataSource is the Prometheus of a cluster, it's defined in config to be part of
+// the config file.
+type DataSource = config.DataSource
 
 type status string
 
@@ -35,10 +43,23 @@ const (
 	statusError   status = "error"
 )
 
-const (
-	NamespaceLabel = "kubernetes_namespace"
-	PodNameLabel = "kubernetes_pod_name"
-)
+// podLabels returns the names of the labels which identify the pods in the Prometheus
+// of cluster, the labels of the cluster override the global ones.
+func (p *PrometheusController) podLabels(cluster string) config.LabelsConfig {
+	labels := config.Get().Labels
+	if ds, err := p.Clusters.Get(cluster); err == nil {
+		labels = labels.Override(ds.Labels)
+	}
+	return labels
+}
+
+// podMatchers returns the matchers selecting the series of pod.
+func podMatchers(labels config.LabelsConfig, namespace, pod string) []*promql.Matcher {
+	return []*promql.Matcher{
+		promql.NewEqualMatcher(labels.Namespace, namespace),
+		promql.NewEqualMatcher(labels.PodName, pod),
+	}
+}
 
 // queryData is just a wrapper to be compatible with the Prometheus API.
 type queryData struct {
@@ -49,26 +70,88 @@ type queryData struct {
 type queryResult struct {
 	Status status      `json:"status"`
 	Data   interface{} `json:"data,omitempty"`
+	ErrorType errorType `json:"errorType,omitempty"`
 	Error  string      `json:"error,omitempty"`
 }
 
-func NewPrometheusController() *PrometheusController {
-	return &PrometheusController{
-		Store:	NewMemoryStore(),
+func (r *queryResult) statusCode() int {
+	if r.Status == statusSuccess {
+		return http.StatusOK
 	}
+	return errorStatusCode(r.ErrorType)
 }
 
-func (p *PrometheusController) getClient(dsInfo *DataSource) (apiv1.API, error) {
-	cfg := api.Config{
-		Address: dsInfo.Url,
+func NewPrometheusController(store Store) (*PrometheusController, error) {
+	podQueries, err := NewPodQueries(config.Get().PodQueriesFile)
+	if err != nil {
+		return nil, fmt.Errorf("load pod queries failed: %v", err)
 	}
 
-	client, err := api.NewClient(cfg)
+	clients := NewClientPool()
+	clusters := NewClusterRegistry()
+	kubeClients := NewKubeClientPool()
+	ruleClients := NewRuleClientPool(kubeClients)
+	clusters.OnChange(clients.Invalidate)
+	clusters.OnChange(kubeClients.Invalidate)
+	clusters.OnChange(ruleClients.Invalidate)
+	config.OnReload(clusters.ConfigReloaded)
+	config.OnReload(podQueries.ConfigReloaded)
+
+	return &PrometheusController{
+		Store:		store,
+		Clusters:	clusters,
+		Clients:	clients,
+		LabelCache:	newTTLCache(config.Get().Cache.LabelTTL, 1024),
+		ResultsCache:	NewResultsCache(config.Get().Cache.ResultsSize),
+		KubeClients:	kubeClients,
+		RuleClients:	ruleClients,
+		PodQueries:	podQueries,
+	}, nil
+}
+
+func (p *PrometheusController) getClient(dsInfo *DataSource) (API, error) {
+	return p.Clients.Get(dsInfo)
+}
+
+// getClusterClient returns the client of the Prometheus which monitors cluster.
+func (p *PrometheusController) getClusterClient(cluster string) (API, error) {
+	ds, err := p.Clusters.Get(cluster)
 	if err != nil {
 		return nil, err
 	}
 
-	return apiv1.NewAPI(client), nil
+	client, err := p.getClient(ds)
+	if err != nil {
+		return nil, err
+	}
+
+	return &instrumentedAPI{API: client, cluster: p.Clusters.metricsLabel(cluster)}, nil
+}
+
+// KubeClient returns the client of the API server of cluster.
+func (p *PrometheusController) KubeClient(cluster string) (*KubeClient, error) {
+	ds, err := p.Clusters.Get(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.KubeClients.Get(ds.Kubernetes)
+}
+
+func (p *PrometheusController) writeResult(result *queryResult) {
+	w := p.Ctx.ResponseWriter
+	b, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(result.statusCode())
+
+	if n, err := w.Write(b); err != nil {
+		logs.Error("Write response body failed: %v, bytesWritten: %v", err, n)
+	}
 }
 
 func parseTime(s string) (time.Time, error) {
@@ -97,74 +180,203 @@ func parseDuration(s string) (time.Duration, error) {
 	return 0, fmt.Errorf("cannot parse %q to a valid duration", s)
 }
 
+// parseStartEnd parses the start and end form values of r.
+func parseStartEnd(r *http.Request) (time.Time, time.Time, error) {
+	start, err := parseTime(r.FormValue("start"))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("Parse start time failed: %v", err)
+	}
+
+	end, err := parseTime(r.FormValue("end"))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("Parse end time failed: %v", err)
+	}
+
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("End before start")
+	}
+
+	return start, end, nil
+}
+
+// parseRange parses the start, end and step form values of r into a range query.
+func parseRange(r *http.Request) (apiv1.Range, error) {
+	start, end, err := parseStartEnd(r)
+	if err != nil {
+		return apiv1.Range{}, err
+	}
+
+	step, err := parseDuration(r.FormValue("step"))
+	if err != nil {
+		return apiv1.Range{}, fmt.Errorf("Parse step failed: %v", err)
+	}
+
+	if step <= 0 {
+		return apiv1.Range{}, fmt.Errorf("Zero or negative query resolution step width are not accepted")
+	}
+
+	return apiv1.Range{
+		Start: start,
+		End:   end,
+		Step:  step,
+	}, nil
+}
+
 func (p *PrometheusController) QueryPod() *queryResult {
 	cluster := p.GetString(":cluster")
 	namespace := p.GetString(":namespace")
 	pod := p.GetString(":pod")
 	logs.Info("cluster: %s, namespace: %s, pod: %s", cluster, namespace, pod)
 
-	client, err := p.getClient(&DataSource{Url: config.PrometheusURL})
+	client, err := p.getClusterClient(cluster)
 	if err != nil {
 		return &queryResult{
 			Status:	statusError,
+			ErrorType:	classifyError(err),
 			Error:	fmt.Sprintf("Get Prometheus client failed: %v", err),
 		}
 	}
 
 	r := p.Ctx.Request
 
-	start, err := parseTime(r.FormValue("start"))
+	timeRange, err := parseRange(r)
 	if err != nil {
 		return &queryResult{
 			Status:	statusError,
-			Error:	fmt.Sprintf("Parse start time failed: %v", err),
+			ErrorType:	errorBadData,
+			Error:	err.Error(),
 		}
 	}
 
-	end, err := parseTime(r.FormValue("end"))
+	offset, err := parseOffset(r)
 	if err != nil {
 		return &queryResult{
 			Status:	statusError,
-			Error:	fmt.Sprintf("Parse end time failed: %v", err),
+			ErrorType:	errorBadData,
+			Error:	err.Error(),
 		}
 	}
 
-	if end.Before(start) {
-		return &queryResult{
-			Status:	statusError,
-			Error:	fmt.Sprintf("End before start"),
+	labels := p.podLabels(cluster)
+
+	// In compare mode, every query runs over the baseline range too.
+	ranges := []taggedRange{{"", timeRange}}
+	if offset != 0 {
+		ranges = []taggedRange{{tagCurrent, timeRange}, {tagBaseline, shiftRange(timeRange, offset)}}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Get().Query.Timeout)
+	defer cancel()
+
+	data := model.Matrix{}
+	for _, pq := range p.PodQueries.Get() {
+		matchPod := promql.MatchersString(podMatchers(labels.Override(pq.Labels), namespace, pod))
+		query := fmt.Sprintf(pq.Query, matchPod)
+		for _, tr := range ranges {
+			value, err := client.QueryRange(ctx, query, tr.Range)
+			if err != nil {
+				return &queryResult{
+					Status:	statusError,
+					ErrorType:	classifyError(err),
+					Error:	fmt.Sprintf("Query Prometheus failed: %v", err),
+				}
+			}
+			matrix, ok := value.(model.Matrix)
+			if !ok {
+				return &queryResult{
+					Status:	statusError,
+					ErrorType:	errorInternal,
+					Error:	fmt.Sprintf("The type of QueryRange value is unexpected"),
+				}
+			}
+			if tr.tag == tagBaseline {
+				shiftMatrix(matrix, offset)
+			}
+			// Add name label to let frontend know the meaning of corresponding samples.
+			for _, sample := range matrix {
+				sample.Metric["name"] = model.LabelValue(pq.Name)
+				if tr.tag != "" {
+					sample.Metric[tagLabel] = model.LabelValue(tr.tag)
+				}
+			}
+			data = append(data, matrix...)
 		}
 	}
 
-	step, err := parseDuration(r.FormValue("step"))
+	return &queryResult{
+		Status:	statusSuccess,
+		Data:	&queryData{
+			ResultType:	"matrix",
+			Result:		data,
+		},
+	}
+}
+
+func (p *PrometheusController) MonitorPod() {
+	format, err := parseExportFormat(p.Ctx.Request)
+	if err != nil {
+		p.writeResult(&queryResult{
+			Status:	statusError,
+			ErrorType:	errorBadData,
+			Error:	err.Error(),
+		})
+		return
+	}
+
+	result := p.QueryPod()
+	data, _ := result.Data.(*queryData)
+	p.writeSeries(format, result, podMatrix(data))
+}
+
+// nodeMatcher returns the matcher selecting the samples of node-exporter on node. The
+// port of node-exporter is not fixed, so the node address is matched with any port.
+// The value is a regex which is escaped again by Matcher.String as a PromQL string.
+func nodeMatcher(node string) *promql.Matcher {
+	return &promql.Matcher{
+		Type:	promql.MatchRegexp,
+		Name:	string(model.InstanceLabel),
+		Value:	regexp.QuoteMeta(node) + "(:[0-9]+)?",
+	}
+}
+
+func (p *PrometheusController) QueryNode() *queryResult {
+	cluster := p.GetString(":cluster")
+	node := p.GetString(":node")
+	logs.Info("cluster: %s, node: %s", cluster, node)
+
+	client, err := p.getClusterClient(cluster)
 	if err != nil {
 		return &queryResult{
 			Status:	statusError,
-			Error:	fmt.Sprintf("Parse step failed: %v", err),
+			ErrorType:	classifyError(err),
+			Error:	fmt.Sprintf("Get Prometheus client failed: %v", err),
 		}
 	}
 
-	if step <= 0 {
+	r := p.Ctx.Request
+
+	timeRange, err := parseRange(r)
+	if err != nil {
 		return &queryResult{
 			Status:	statusError,
-			Error:	fmt.Sprintf("Zero or negative query resolution step width are not accepted"),
+			ErrorType:	errorBadData,
+			Error:	err.Error(),
 		}
 	}
 
-	timeRange := apiv1.Range{
-		Start: start,
-		End:   end,
-		Step:  step,
-	}
-	data := model.Matrix{}
+	matchNode := nodeMatcher(node).String()
 
-	queries := []string{"up", "process_start_time_seconds"}
+	ctx, cancel := context.WithTimeout(r.Context(), config.Get().Query.Timeout)
+	defer cancel()
 
-	for _, query := range queries {
-		value, err := client.QueryRange(context.Background(), query, timeRange)
+	data := model.Matrix{}
+	for _, nq := range nodeQueries {
+		query := fmt.Sprintf(nq.Query, matchNode)
+		value, err := client.QueryRange(ctx, query, timeRange)
 		if err != nil {
 			return &queryResult{
 				Status:	statusError,
+				ErrorType:	classifyError(err),
 				Error:	fmt.Sprintf("Query Prometheus failed: %v", err),
 			}
 		}
@@ -172,18 +384,15 @@ func (p *PrometheusController) QueryPod() *queryResult {
 		if !ok {
 			return &queryResult{
 				Status:	statusError,
+				ErrorType:	errorInternal,
 				Error:	fmt.Sprintf("The type of QueryRange value is unexpected"),
 			}
 		}
-		// Actually the maxtrix length should always be 1.
+		// The queries are all aggregated, so there is at most one sample stream.
 		if len(matrix) == 0 {
-			return &queryResult{
-				Status:	statusError,
-				Error:	fmt.Sprintf("The length of QueryRange value is 0"),
-			}
+			continue
 		}
-		// Add name label to let frontend know the meaning of corresponding samples.
-		matrix[0].Metric["name"] = "cpu_usage"
+		matrix[0].Metric["name"] = model.LabelValue(nq.Name)
 		data = append(data, matrix[0])
 	}
 
@@ -196,37 +405,8 @@ func (p *PrometheusController) QueryPod() *queryResult {
 	}
 }
 
-func (p *PrometheusController) MonitorPod() {
-	w := p.Ctx.ResponseWriter
-	result := p.QueryPod()
-	b, err := json.Marshal(result)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	if result.Status == statusSuccess {
-		w.WriteHeader(http.StatusOK)
-	} else {
-		// More refined in the future.
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-
-	if n, err := w.Write(b); err != nil {
-		logs.Error("Write response body failed: %v, bytesWritten: %v", err, n)
-	}
-}
-
 func (p *PrometheusController) MonitorNode() {
-
-}
-
-type metricList struct {
-	Counter		[]string `json:"counter"`
-	Gauge 		[]string `json:"gauge"`
-	Summary		[]string `json:"summary"`
-	Histogram	[]string `json:"histogram"`
+	p.writeResult(p.QueryNode())
 }
 
 func (p *PrometheusController) QueryPodMetrics() *queryResult {
@@ -235,70 +415,114 @@ func (p *PrometheusController) QueryPodMetrics() *queryResult {
 	pod := p.GetString(":pod")
 	logs.Info("cluster: %s, namespace: %s, pod: %s", cluster, namespace, pod)
 
-	client, err := p.getClient(&DataSource{Url: config.PrometheusURL})
+	client, err := p.getClusterClient(cluster)
 	if err != nil {
 		return &queryResult{
 			Status:	statusError,
+			ErrorType:	classifyError(err),
 			Error:	fmt.Sprintf("Get Prometheus client failed: %v", err),
 		}
 	}
 
-	matchTarget := fmt.Sprintf("{%s=\"%s\", %s=\"%s\"}", NamespaceLabel, namespace, PodNameLabel, pod)
+	matchTarget := promql.NewSelector("", podMatchers(p.podLabels(cluster), namespace, pod)...).String()
 
-	metrics, err := client.TargetsMetadata(context.Background(), matchTarget)
+	ctx, cancel := context.WithTimeout(p.Ctx.Request.Context(), config.Get().Query.Timeout)
+	defer cancel()
+
+	metrics, err := client.TargetsMetadata(ctx, matchTarget)
 	if err != nil {
 		return &queryResult{
 			Status:	statusError,
+			ErrorType:	classifyError(err),
 			Error:	fmt.Sprintf("Get targets metadata failed: %v", err),
 		}
 	}
 
-	mlist := &metricList{}
-	for _, metric := range metrics {
-		switch metric.Type {
-		case "counter":
-			mlist.Counter = append(mlist.Counter, metric.Metric)
+	return &queryResult{
+		Status:	statusSuccess,
+		Data:	newMetricList(metrics, nil, matchTarget),
+	}
+}
 
-		case "gauge":
-			mlist.Gauge = append(mlist.Gauge, metric.Metric)
+func (p *PrometheusController) PodMetrics() {
+	p.writeResult(p.QueryPodMetrics())
+}
 
-		case "summary":
-			mlist.Summary = append(mlist.Summary, metric.Metric)
+// podSelector parses metric posted by the user, which must be a metric name or a
+// selector, and merges the pod matchers into it, so it only selects the series of the
+// pod whatever its own matchers are.
+func podSelector(metric string, matchers []*promql.Matcher) (*promql.Selector, error) {
+	s, err := promql.ParseSelector(metric)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid metric %q: %v", metric, err)
+	}
+	return s.With(matchers...), nil
+}
 
-		case "histogram":
-			mlist.Histogram = append(mlist.Histogram, metric.Metric)
-		}
+// normalizeRecord parses metric posted by the user and returns the form saved in the
+// pod metrics records, which is the selector of the user with its matchers sorted. The
+// pod matchers are not saved but implied by the id of the records, so the records
+// still match after the pod labels are renamed.
+func normalizeRecord(metric string) (string, error) {
+	s, err := promql.ParseSelector(metric)
+	if err != nil {
+		return "", fmt.Errorf("Invalid metric %q: %v", metric, err)
 	}
+	return recordString(s), nil
+}
 
-	return &queryResult{
-		Status:	statusSuccess,
-		Data:	mlist,
+// recordString returns s in the form of pod metrics records.
+func recordString(s *promql.Selector) string {
+	s = s.Sorted()
+	if len(s.Matchers) == 0 {
+		return s.Name
 	}
+	return s.String()
 }
 
-func (p *PrometheusController) PodMetrics() {
-	w := p.Ctx.ResponseWriter
+// podRecordsID returns the id of the pod metrics records in Store.
+func podRecordsID(cluster, namespace, pod string) string {
+	return fmt.Sprintf("%s-%s-%s", cluster, namespace, pod)
+}
 
-	result := p.QueryPodMetrics()
-	b, err := json.Marshal(result)
+func (p *PrometheusController) QueryPodMetricsRecords() *queryResult {
+	cluster := p.GetString(":cluster")
+	namespace := p.GetString(":namespace")
+	pod := p.GetString(":pod")
+	logs.Info("cluster: %s, namespace: %s, pod: %s", cluster, namespace, pod)
+
+	if _, err := p.Clusters.Get(cluster); err != nil {
+		return &queryResult{
+			Status:	statusError,
+			ErrorType:	classifyError(err),
+			Error:	err.Error(),
+		}
+	}
+
+	metrics, err := p.Store.GetPodMetricsRecords(podRecordsID(cluster, namespace, pod))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return &queryResult{
+			Status:	statusError,
+			ErrorType:	errorInternal,
+			Error:	fmt.Sprintf("Get pod metrics records failed: %v", err),
+		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if result.Status == statusSuccess {
-		w.WriteHeader(http.StatusOK)
-	} else {
-		// More refined in the future.
-		w.WriteHeader(http.StatusInternalServerError)
+	// Let the frontend always get an array.
+	if metrics == nil {
+		metrics = []string{}
 	}
 
-	if n, err := w.Write(b); err != nil {
-		logs.Error("Write response body failed: %v, bytesWritten: %v", err, n)
+	return &queryResult{
+		Status:	statusSuccess,
+		Data:	metrics,
 	}
 }
 
+func (p *PrometheusController) ListPodMetricsRecords() {
+	p.writeResult(p.QueryPodMetricsRecords())
+}
+
 func (p *PrometheusController) PodMetricsRecords() {
 	cluster := p.GetString(":cluster")
 	namespace := p.GetString(":namespace")
@@ -309,38 +533,53 @@ func (p *PrometheusController) PodMetricsRecords() {
 	w := p.Ctx.ResponseWriter
 	operation := r.Header.Get("Operation")
 
+	if _, err := p.Clusters.Get(cluster); err != nil {
+		http.Error(w, err.Error(), errorStatusCode(classifyError(err)))
+		return
+	}
+
 	b := r.PostFormValue("metrics")
 	var metrics []string
 	err := json.Unmarshal([]byte(b), &metrics)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Deduplicate metrics.
+	// Deduplicate metrics, the equivalent selectors have the same normalized form.
 	unique := make(map[string]struct{})
 	for _, metric := range metrics {
-		unique[metric] = struct{}{}
+		record, err := normalizeRecord(metric)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		unique[record] = struct{}{}
 	}
 
 	metrics = nil
 	for metric, _ := range unique {
-		metrics = append(metrics, fmt.Sprintf("%s{%s=\"%s\", %s=\"%s\"}", metric, NamespaceLabel, namespace, PodNameLabel, pod))
+		metrics = append(metrics, metric)
 	}
 
-	id := fmt.Sprintf("%s-%s-%s", cluster, namespace, pod)
+	id := podRecordsID(cluster, namespace, pod)
 	switch operation {
 	case "Add":
-		p.Store.AddPodMetricsRecords(id, metrics)
+		err = p.Store.AddPodMetricsRecords(id, metrics)
 
 	case "Delete":
-		p.Store.DeletePodMetricsRecords(id, metrics)
+		err = p.Store.DeletePodMetricsRecords(id, metrics)
 
 	case "Reset":
-		p.Store.ResetPodMetricsRecords(id, metrics)
+		err = p.Store.ResetPodMetricsRecords(id, metrics)
 
 	default:
-		http.Error(w, fmt.Errorf("undefined pod metrics records operation").Error(), http.StatusInternalServerError)
+		http.Error(w, fmt.Errorf("undefined pod metrics records operation").Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%s pod metrics records failed: %v", operation, err), http.StatusInternalServerError)
 		return
 	}
 
@@ -348,30 +587,43 @@ func (p *PrometheusController) PodMetricsRecords() {
 }
 
 func (p *PrometheusController) PodSeries() {
-	w := p.Ctx.ResponseWriter
-	result := p.QueryPodSeries()
-	b, err := json.Marshal(result)
+	format, err := parseExportFormat(p.Ctx.Request)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		p.writeResult(&queryResult{
+			Status:	statusError,
+			ErrorType:	errorBadData,
+			Error:	err.Error(),
+		})
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if result.Status == statusSuccess {
-		w.WriteHeader(http.StatusOK)
-	} else {
-		// More refined in the future.
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-
-	if n, err := w.Write(b); err != nil {
-		logs.Error("Write response body failed: %v, bytesWritten: %v", err, n)
-	}
+	result := p.QueryPodSeries()
+	data, _ := result.Data.([]*series)
+	p.writeSeries(format, result, seriesMatrix(data))
 }
 
+// seriesSource tells where the metrics of series come from.
+type seriesSource string
+
+const (
+	// The metrics are posted with the request.
+	sourceAdhoc seriesSource = "adhoc"
+	// The metrics are the saved pod metrics records.
+	sourceStored seriesSource = "stored"
+)
+
 type series struct {
 	Name 	string 			`json:"name"`
+	Source	seriesSource	`json:"source"`
+	// Tag is only set in compare mode, in which every metric has a baseline series.
+	Tag		seriesTag		`json:"tag,omitempty"`
 	Result 	model.Matrix 	`json:"result"`
+	// Stats are the statistics of the series in Result of the same index, which are
+	// only computed if requested.
+	Stats	[]*seriesStats	`json:"stats,omitempty"`
+	// Error is set if the query of this metric failed, the others are still returned.
+	ErrorType	errorType	`json:"errorType,omitempty"`
+	Error		string		`json:"error,omitempty"`
 }
 
 func (p *PrometheusController) QueryPodSeries() *queryResult {
@@ -382,69 +634,83 @@ func (p *PrometheusController) QueryPodSeries() *queryResult {
 
 	r := p.Ctx.Request
 
-	start, err := parseTime(r.FormValue("start"))
+	timeRange, err := parseSeriesRange(r)
 	if err != nil {
 		return &queryResult{
 			Status:	statusError,
-			Error:	fmt.Sprintf("Parse start time failed: %v", err),
+			ErrorType:	errorBadData,
+			Error:	err.Error(),
 		}
 	}
 
-	end, err := parseTime(r.FormValue("end"))
+	offset, err := parseOffset(r)
 	if err != nil {
 		return &queryResult{
 			Status:	statusError,
-			Error:	fmt.Sprintf("Parse end time failed: %v", err),
+			ErrorType:	errorBadData,
+			Error:	err.Error(),
 		}
 	}
 
-	if end.Before(start) {
-		return &queryResult{
-			Status:	statusError,
-			Error:	fmt.Sprintf("End before start"),
+	withStats := false
+	if s := r.FormValue("stats"); s != "" {
+		if withStats, err = strconv.ParseBool(s); err != nil {
+			return &queryResult{
+				Status:	statusError,
+				ErrorType:	errorBadData,
+				Error:	fmt.Sprintf("Parse stats failed: %v", err),
+			}
 		}
 	}
 
-	step, err := parseDuration(r.FormValue("step"))
-	if err != nil {
-		return &queryResult{
-			Status:	statusError,
-			Error:	fmt.Sprintf("Parse step failed: %v", err),
-		}
-	}
+	labels := p.podLabels(cluster)
+	var metrics, queries []string
+	source := sourceAdhoc
 
-	if step <= 0 {
-		return &queryResult{
-			Status:	statusError,
-			Error:	fmt.Sprintf("Zero or negative query resolution step width are not accepted"),
+	b := r.PostFormValue("metrics")
+	if b == "" {
+		// No metrics posted, fall back to the saved pod metrics records.
+		records, err := p.Store.GetPodMetricsRecords(podRecordsID(cluster, namespace, pod))
+		if err != nil {
+			return &queryResult{
+				Status:	statusError,
+				ErrorType:	errorInternal,
+				Error:	fmt.Sprintf("Get pod metrics records failed: %v", err),
+			}
 		}
-	}
 
-	timeRange := apiv1.Range{
-		Start: start,
-		End:   end,
-		Step:  step,
-	}
-
-	b := r.PostFormValue("metrics")
-	var metrics []string
-	err = json.Unmarshal([]byte(b), &metrics)
-	if err != nil {
-		return &queryResult{
-			Status:	statusError,
-			Error:	fmt.Sprintf("Unmarshal metrics from post form failed: %v", err),
+		source = sourceStored
+		metrics = records
+	} else {
+		err = json.Unmarshal([]byte(b), &metrics)
+		if err != nil {
+			return &queryResult{
+				Status:	statusError,
+				ErrorType:	errorBadData,
+				Error:	fmt.Sprintf("Unmarshal metrics from post form failed: %v", err),
+			}
 		}
+
 	}
 
-	var queries []string
+	matchers := podMatchers(labels, namespace, pod)
 	for _, metric := range metrics {
-		queries = append(queries, fmt.Sprintf("%s{%s=\"%s\", %s=\"%s\"}", metric, NamespaceLabel, namespace, PodNameLabel, pod))
+		s, err := podSelector(metric, matchers)
+		if err != nil {
+			return &queryResult{
+				Status:	statusError,
+				ErrorType:	errorBadData,
+				Error:	err.Error(),
+			}
+		}
+		queries = append(queries, s.String())
 	}
 
-	client, err := p.getClient(&DataSource{Url: config.PrometheusURL})
+	client, err := p.getCachingClient(cluster)
 	if err != nil {
 		return &queryResult{
 			Status:	statusError,
+			ErrorType:	classifyError(err),
 			Error:	fmt.Sprintf("Get Prometheus client failed: %v", err),
 		}
 	}
@@ -454,39 +720,91 @@ func (p *PrometheusController) QueryPodSeries() *queryResult {
 		model.MetricNameLabel,
 		model.JobLabel,
 		model.InstanceLabel,
-		NamespaceLabel,
-		PodNameLabel,
+		model.LabelName(labels.Namespace),
+		model.LabelName(labels.PodName),
 	}
 
-	data := []*series{}
-	for i, query := range queries {
-		value, err := client.QueryRange(context.Background(), query, timeRange)
-		if err != nil {
-			return &queryResult{
-				Status:	statusError,
-				Error:	fmt.Sprintf("Query Prometheus failed: %v", err),
+	ctx, cancel := context.WithTimeout(r.Context(), config.Get().Query.Timeout)
+	defer cancel()
+
+	results := queryRangeAll(ctx, client, queries, timeRange.Range, config.Get().Query.Concurrency)
+
+	// The baseline is queried after the current range to respect the concurrency.
+	var baselines []rangeQueryResult
+	if offset != 0 {
+		baselines = queryRangeAll(ctx, client, queries, shiftRange(timeRange.Range, offset), config.Get().Query.Concurrency)
+		for _, baseline := range baselines {
+			if baseline.Err == nil {
+				shiftMatrix(baseline.Matrix, offset)
 			}
 		}
-		matrix, ok := value.(model.Matrix)
-		if !ok {
-			return &queryResult{
-				Status:	statusError,
-				Error:	fmt.Sprintf("The type of QueryRange value is unexpected"),
-			}
+	}
+
+	var types map[string]string
+	if withStats {
+		match := promql.NewSelector("", podMatchers(labels, namespace, pod)...).String()
+		types = metricTypes(ctx, client, match)
+	}
+
+	data := []*series{}
+	failed := 0
+	appendSeries := func(i int, result rangeQueryResult, tag seriesTag) {
+		if result.Err != nil {
+			// Report the failure of a single metric as a partial result.
+			failed++
+			data = append(data, &series{
+				Name:	metrics[i],
+				Source:	source,
+				Tag:	tag,
+				ErrorType:	classifyError(result.Err),
+				Error:	fmt.Sprintf("Query Prometheus failed: %v", result.Err),
+			})
+			return
 		}
 
 		for _, label := range unidentify {
-			for _, sample := range matrix {
+			for _, sample := range result.Matrix {
 				delete(sample.Metric, label)
 			}
 		}
 
+		// The stats are computed before downsampling to be accurate.
+		var stats []*seriesStats
+		if withStats {
+			counter := isCounter(types, metrics[i])
+			for _, sample := range result.Matrix {
+				stats = append(stats, newSeriesStats(sample.Values, counter))
+			}
+		}
+
+		downsample(result.Matrix, timeRange.Downsample, timeRange.MaxDataPoints)
+
 		data = append(data, &series{
 			Name:	metrics[i],
-			Result:	matrix,
+			Source:	source,
+			Tag:	tag,
+			Result:	result.Matrix,
+			Stats:	stats,
 		})
 	}
 
+	for i, result := range results {
+		if baselines == nil {
+			appendSeries(i, result, "")
+			continue
+		}
+		appendSeries(i, result, tagCurrent)
+		appendSeries(i, baselines[i], tagBaseline)
+	}
+
+	if failed != 0 && failed == len(data) {
+		return &queryResult{
+			Status:	statusError,
+			ErrorType:	data[0].ErrorType,
+			Error:	data[0].Error,
+		}
+	}
+
 	return &queryResult{
 		Status:	statusSuccess,
 		Data:	data,
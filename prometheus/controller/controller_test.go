@@ -0,0 +1,263 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/YaoZengzeng/practice/prometheus/config"
+	"github.com/YaoZengzeng/practice/prometheus/promql"
+
+	beecontext "github.com/astaxie/beego/context"
+	"github.com/prometheus/common/model"
+)
+
+func TestPodSelector(t *testing.T) {
+	matchers := podMatchers(config.LabelsConfig{Namespace: "namespace", PodName: "pod"}, "default", "foo")
+
+	for _, c := range []struct {
+		metric string
+		want   string
+	}{
+		{"up", `up{namespace="default", pod="foo"}`},
+		{`up{job="node"}`, `up{job="node", namespace="default", pod="foo"}`},
+		// The matchers of the user could only narrow down the series of the pod.
+		{`up{pod="bar"}`, `up{pod="bar", namespace="default", pod="foo"}`},
+		{`{__name__=~"up|down"}`, `{__name__=~"up|down", namespace="default", pod="foo"}`},
+	} {
+		s, err := podSelector(c.metric, matchers)
+		if err != nil {
+			t.Errorf("podSelector(%s) failed: %v", c.metric, err)
+			continue
+		}
+		if got := s.String(); got != c.want {
+			t.Errorf("podSelector(%s) = %s, want %s", c.metric, got, c.want)
+		}
+	}
+
+	for _, metric := range []string{`up) or (secret_metric`, `up{job="node"} or secret_metric`, `sum(up)`, `up[5m]`, ``} {
+		if _, err := podSelector(metric, matchers); err == nil {
+			t.Errorf("podSelector(%s) should fail", metric)
+		}
+	}
+}
+
+func TestNormalizeRecord(t *testing.T) {
+	for _, c := range []struct {
+		metric string
+		want   string
+	}{
+		{"up", "up"},
+		{"up{}", "up"},
+		{`up{job="node"}`, `up{job="node"}`},
+		{`up{pod="foo",job='node'}`, `up{job="node", pod="foo"}`},
+		{`up{job="node", job="node"}`, `up{job="node"}`},
+		{`{__name__=~"up|down"}`, `{__name__=~"up|down"}`},
+	} {
+		got, err := normalizeRecord(c.metric)
+		if err != nil {
+			t.Errorf("normalizeRecord(%s) failed: %v", c.metric, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("normalizeRecord(%s) = %s, want %s", c.metric, got, c.want)
+		}
+	}
+
+	if _, err := normalizeRecord(`up) or (secret_metric`); err == nil {
+		t.Errorf("normalizeRecord should reject an expression")
+	}
+}
+
+func TestNormalizeLegacyRecord(t *testing.T) {
+	defaultLabels := config.LabelsConfig{Namespace: "kubernetes_namespace", PodName: "kubernetes_pod_name"}
+	kubeLabels := config.LabelsConfig{Namespace: "namespace", PodName: "pod"}
+
+	for _, c := range []struct {
+		id     string
+		record string
+		labels config.LabelsConfig
+		want   string
+	}{
+		{"ca-default-foo", `up{kubernetes_namespace="default", kubernetes_pod_name="foo"}`, defaultLabels, "up"},
+		{"ca-default-foo", `up{namespace="default", pod="foo"}`, kubeLabels, "up"},
+		{"ca-default-foo", `up{pod="foo", job="node", namespace="default", pod="foo"}`, kubeLabels, `up{job="node", pod="foo"}`},
+		// Not saved with the pod matchers.
+		{"ca-default-foo", `up{job="node"}`, defaultLabels, `up{job="node"}`},
+		{"ca-default-bar", `up{namespace="default", pod="foo"}`, kubeLabels, `up{namespace="default", pod="foo"}`},
+		{"ca-default-foo", `up{namespace="default", pod="foo"}`, defaultLabels, `up{namespace="default", pod="foo"}`},
+		{"ca-default-nginx", `up{job="default", instance="nginx"}`, defaultLabels, `up{instance="nginx", job="default"}`},
+	} {
+		got, err := normalizeLegacyRecord(c.id, c.record, c.labels)
+		if err != nil {
+			t.Errorf("normalizeLegacyRecord(%s, %s) failed: %v", c.id, c.record, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("normalizeLegacyRecord(%s, %s) = %s, want %s", c.id, c.record, got, c.want)
+		}
+	}
+
+	for _, record := range []string{`up) or (secret_metric{namespace="default", pod="foo"}`, `{namespace="default", pod="foo"}`} {
+		if _, err := normalizeLegacyRecord("ca-default-foo", record, kubeLabels); err == nil {
+			t.Errorf("normalizeLegacyRecord(%s) should fail", record)
+		}
+	}
+}
+
+func TestLegacyLabels(t *testing.T) {
+	cfg := &config.Config{Labels: config.LabelsConfig{Namespace: "kubernetes_namespace", PodName: "kubernetes_pod_name"}}
+	clusters := map[string]*DataSource{
+		"ca":      {Url: "http://ca"},
+		"ca-kube": {Url: "http://ca-kube", Labels: config.LabelsConfig{Namespace: "namespace", PodName: "pod"}},
+	}
+
+	for _, c := range []struct {
+		id   string
+		want config.LabelsConfig
+	}{
+		{"ca-default-foo", cfg.Labels},
+		{"ca-kube-default-foo", config.LabelsConfig{Namespace: "namespace", PodName: "pod"}},
+		// The cluster is not listed.
+		{"cb-default-foo", cfg.Labels},
+	} {
+		if got := legacyLabels(cfg, clusters, c.id); got != c.want {
+			t.Errorf("legacyLabels(%s) = %v, want %v", c.id, got, c.want)
+		}
+	}
+}
+
+func TestPodMetricsRecords(t *testing.T) {
+	store := NewMemoryStore()
+	p := &PrometheusController{
+		Store:    store,
+		Clusters: NewClusterRegistry(),
+	}
+
+	post := func(operation, metrics string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(url.Values{"metrics": {metrics}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Operation", operation)
+		w := httptest.NewRecorder()
+		p.Ctx = beecontext.NewContext()
+		p.Ctx.Reset(w, r)
+		p.Ctx.Input.SetParam(":cluster", "ca")
+		p.Ctx.Input.SetParam(":namespace", "default")
+		p.Ctx.Input.SetParam(":pod", "foo")
+
+		p.PodMetricsRecords()
+		return w
+	}
+
+	w := post("Add", `["up) or (secret_metric"]`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "only a metric name with optional label matchers is allowed") {
+		t.Errorf("expected 400 with the reason, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := post("Add", `["up", "up{job=\"node\", pod=\"foo\"}", "up{}"]`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	records, err := store.GetPodMetricsRecords(podRecordsID("ca", "default", "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"up",
+		`up{job="node", pod="foo"}`,
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %v, want %v", records, want)
+	}
+
+	// The equivalent selector deletes the record.
+	if w := post("Delete", `["up{pod='foo',job='node'}"]`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if records, _ := store.GetPodMetricsRecords(podRecordsID("ca", "default", "foo")); !reflect.DeepEqual(records, []string{"up"}) {
+		t.Errorf("records after delete = %v, want [up]", records)
+	}
+}
+
+func TestNodeMatcher(t *testing.T) {
+	// The escaped regex must also be a valid PromQL string.
+	s, err := promql.ParseSelector("up{" + nodeMatcher("10.0.0.1").String() + "}")
+	if err != nil {
+		t.Fatalf("invalid node matcher: %v", err)
+	}
+	if want := `10\.0\.0\.1(:[0-9]+)?`; s.Matchers[0].Value != want {
+		t.Errorf("node matcher value = %s, want %s", s.Matchers[0].Value, want)
+	}
+
+	re := regexp.MustCompile("^(?:" + s.Matchers[0].Value + ")$")
+	for instance, want := range map[string]bool{
+		"10.0.0.1":       true,
+		"10.0.0.1:9100":  true,
+		"10.0.0.11:9100": false,
+		"10x0x0x1:9100":  false,
+	} {
+		if got := re.MatchString(instance); got != want {
+			t.Errorf("node matcher matches %s = %v, want %v", instance, got, want)
+		}
+	}
+}
+
+func TestMonitorNode(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.FormValue("query"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[[1,"0.5"]]}]}}`))
+	}))
+	defer server.Close()
+
+	defer config.Set(config.Get())
+	cfg := *config.Get()
+	cfg.PrometheusURL = server.URL
+	cfg.Clusters = nil
+	cfg.ClustersFile = ""
+	config.Set(&cfg)
+
+	p := &PrometheusController{
+		Clusters: NewClusterRegistry(),
+		Clients:  NewClientPool(),
+	}
+	r := httptest.NewRequest("GET", "/?start=0&end=3600&step=60", nil)
+	w := httptest.NewRecorder()
+	p.Ctx = beecontext.NewContext()
+	p.Ctx.Reset(w, r)
+	p.Ctx.Input.SetParam(":cluster", "ca")
+	p.Ctx.Input.SetParam(":node", "10.0.0.1")
+
+	p.MonitorNode()
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(queries) != len(nodeQueries) {
+		t.Fatalf("got %d queries, want %d", len(queries), len(nodeQueries))
+	}
+	for _, query := range queries {
+		if !strings.Contains(query, `instance=~"10\\.0\\.0\\.1(:[0-9]+)?"`) {
+			t.Errorf("query %s does not select the node", query)
+		}
+	}
+
+	var result struct {
+		Data struct {
+			Result model.Matrix `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	for i, series := range result.Data.Result {
+		if name := string(series.Metric["name"]); name != nodeQueries[i].Name {
+			t.Errorf("series %d is named %s, want %s", i, name, nodeQueries[i].Name)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/YaoZengzeng/practice/prometheus/config"
+
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// downsampleMode is how the samples of a series are reduced to the max data points.
+type downsampleMode string
+
+const (
+	// The step is enlarged so that Prometheus returns no more than max data points.
+	downsampleNone downsampleMode = ""
+	// Largest-Triangle-Three-Buckets, which keeps the visual shape of the series.
+	downsampleLTTB downsampleMode = "lttb"
+	// The minimum and maximum samples of every bucket, which keeps the spikes.
+	downsampleMinMax downsampleMode = "minmax"
+)
+
+// seriesRange is the range of the series queries and how to limit their data points.
+type seriesRange struct {
+	apiv1.Range
+
+	MaxDataPoints int
+	Downsample    downsampleMode
+}
+
+// pointsOf returns the number of data points Prometheus returns for a series of r.
+func pointsOf(r apiv1.Range) int {
+	return int(r.End.Sub(r.Start)/r.Step) + 1
+}
+
+// stepFor returns the minimum step in whole seconds so that a series between start
+// and end has at most maxPoints data points.
+func stepFor(start, end time.Time, maxPoints int) time.Duration {
+	if maxPoints <= 1 {
+		return end.Sub(start) + time.Second
+	}
+	step := time.Duration(math.Ceil(end.Sub(start).Seconds()/float64(maxPoints-1))) * time.Second
+	if step <= 0 {
+		step = time.Second
+	}
+	return step
+}
+
+// parseSeriesRange is like parseRange, but the step is optional if maxDataPoints is
+// specified, and is clamped so that the points of a series are limited.
+func parseSeriesRange(r *http.Request) (*seriesRange, error) {
+	start, end, err := parseStartEnd(r)
+	if err != nil {
+		return nil, err
+	}
+
+	sr := &seriesRange{
+		Range: apiv1.Range{
+			Start: start,
+			End:   end,
+		},
+		MaxDataPoints: config.Get().Query.MaxDataPoints,
+		Downsample:    downsampleMode(r.FormValue("downsample")),
+	}
+
+	switch sr.Downsample {
+	case downsampleNone, downsampleLTTB, downsampleMinMax:
+	default:
+		return nil, fmt.Errorf("Unknown downsample mode %q", sr.Downsample)
+	}
+
+	if s := r.FormValue("maxDataPoints"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("maxDataPoints should be a positive integer")
+		}
+		if n < sr.MaxDataPoints {
+			sr.MaxDataPoints = n
+		}
+	}
+
+	if s := r.FormValue("step"); s != "" {
+		sr.Step, err = parseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("Parse step failed: %v", err)
+		}
+		if sr.Step <= 0 {
+			return nil, fmt.Errorf("Zero or negative query resolution step width are not accepted")
+		}
+	} else if r.FormValue("maxDataPoints") == "" {
+		return nil, fmt.Errorf("Either step or maxDataPoints should be specified")
+	}
+
+	// Let Prometheus return at most max data points unless they are downsampled here,
+	// in which case only the limit of Prometheus itself is respected.
+	limit := sr.MaxDataPoints
+	if sr.Downsample != downsampleNone {
+		limit = config.Get().Query.MaxDataPoints
+	}
+	if sr.Step == 0 || pointsOf(sr.Range) > limit {
+		sr.Step = stepFor(start, end, limit)
+	}
+
+	return sr, nil
+}
+
+// downsample reduces the values of every series in matrix to at most maxPoints.
+func downsample(matrix model.Matrix, mode downsampleMode, maxPoints int) {
+	for _, ss := range matrix {
+		switch mode {
+		case downsampleLTTB:
+			ss.Values = lttb(ss.Values, maxPoints)
+		case downsampleMinMax:
+			ss.Values = minMax(ss.Values, maxPoints)
+		}
+	}
+}
+
+// lttb implements the Largest-Triangle-Three-Buckets algorithm, see
+// https://skemman.is/bitstream/1946/15343/3/SS_MSthesis.pdf for details.
+func lttb(values []model.SamplePair, threshold int) []model.SamplePair {
+	if threshold >= len(values) || threshold < 3 {
+		return values
+	}
+
+	sampled := make([]model.SamplePair, 0, threshold)
+	// The first and last points are always kept, the others are split into buckets.
+	every := float64(len(values)-2) / float64(threshold-2)
+
+	a := 0
+	sampled = append(sampled, values[a])
+	for i := 0; i < threshold-2; i++ {
+		// The average point of the next bucket.
+		avgStart := int(math.Floor(float64(i+1)*every)) + 1
+		avgEnd := int(math.Floor(float64(i+2)*every)) + 1
+		if avgEnd > len(values) {
+			avgEnd = len(values)
+		}
+		var avgX, avgY float64
+		for j := avgStart; j < avgEnd; j++ {
+			avgX += float64(values[j].Timestamp)
+			avgY += float64(values[j].Value)
+		}
+		n := float64(avgEnd - avgStart)
+		avgX /= n
+		avgY /= n
+
+		// The point of the current bucket forming the largest triangle.
+		start := int(math.Floor(float64(i)*every)) + 1
+		end := int(math.Floor(float64(i+1)*every)) + 1
+		ax, ay := float64(values[a].Timestamp), float64(values[a].Value)
+		maxArea, next := -1.0, start
+		for j := start; j < end; j++ {
+			area := math.Abs((ax-avgX)*(float64(values[j].Value)-ay) - (ax-float64(values[j].Timestamp))*(avgY-ay))
+			if area > maxArea {
+				maxArea, next = area, j
+			}
+		}
+
+		sampled = append(sampled, values[next])
+		a = next
+	}
+	sampled = append(sampled, values[len(values)-1])
+
+	return sampled
+}
+
+// minMax splits values into threshold/2 buckets and keeps the minimum and maximum
+// points of every bucket in time order.
+func minMax(values []model.SamplePair, threshold int) []model.SamplePair {
+	buckets := threshold / 2
+	if threshold >= len(values) || buckets < 1 {
+		return values
+	}
+
+	sampled := make([]model.SamplePair, 0, buckets*2)
+	size := float64(len(values)) / float64(buckets)
+	for i := 0; i < buckets; i++ {
+		start := int(float64(i) * size)
+		end := int(float64(i+1) * size)
+		if i == buckets-1 {
+			end = len(values)
+		}
+
+		min, max := start, start
+		for j := start; j < end; j++ {
+			if values[j].Value < values[min].Value {
+				min = j
+			}
+			if values[j].Value > values[max].Value {
+				max = j
+			}
+		}
+
+		switch {
+		case min == max:
+			sampled = append(sampled, values[min])
+		case min < max:
+			sampled = append(sampled, values[min], values[max])
+		default:
+			sampled = append(sampled, values[max], values[min])
+		}
+	}
+
+	return sampled
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func sineValues(n int) []model.SamplePair {
+	values := make([]model.SamplePair, n)
+	for i := range values {
+		values[i] = model.SamplePair{
+			Timestamp: model.Time(i * 1000),
+			Value:     model.SampleValue(math.Sin(float64(i) / 10)),
+		}
+	}
+	return values
+}
+
+func TestLTTB(t *testing.T) {
+	values := sineValues(1000)
+
+	sampled := lttb(values, 100)
+	if len(sampled) != 100 {
+		t.Fatalf("lttb returns %d points, want 100", len(sampled))
+	}
+	if sampled[0] != values[0] || sampled[99] != values[999] {
+		t.Errorf("the first and last points should be kept")
+	}
+	for i := 1; i < len(sampled); i++ {
+		if sampled[i].Timestamp <= sampled[i-1].Timestamp {
+			t.Fatalf("points are not in time order at %d", i)
+		}
+	}
+
+	if got := lttb(values[:10], 100); len(got) != 10 {
+		t.Errorf("series shorter than threshold should not be downsampled")
+	}
+}
+
+func TestMinMax(t *testing.T) {
+	values := sineValues(1000)
+	values[500].Value = 100
+
+	sampled := minMax(values, 100)
+	if len(sampled) > 100 {
+		t.Fatalf("minMax returns %d points, want at most 100", len(sampled))
+	}
+	spike := false
+	for i, v := range sampled {
+		if i > 0 && v.Timestamp <= sampled[i-1].Timestamp {
+			t.Fatalf("points are not in time order at %d", i)
+		}
+		if v.Value == 100 {
+			spike = true
+		}
+	}
+	if !spike {
+		t.Errorf("the spike should be kept")
+	}
+}
+
+func TestParseSeriesRange(t *testing.T) {
+	tc := []struct {
+		query string
+		step  time.Duration
+		err   bool
+	}{
+		{
+			"start=0&end=3600&step=15s", 15 * time.Second, false,
+		},
+		{
+			// One week at 1s step exceeds the limit of Prometheus.
+			"start=0&end=604800&step=1s", 55 * time.Second, false,
+		},
+		{
+			"start=0&end=3600&maxDataPoints=61", time.Minute, false,
+		},
+		{
+			"start=0&end=3600&step=15s&maxDataPoints=61", time.Minute, false,
+		},
+		{
+			// The series are downsampled, so the fine step is kept.
+			"start=0&end=3600&step=15s&maxDataPoints=61&downsample=lttb", 15 * time.Second, false,
+		},
+		{
+			"start=0&end=3600", 0, true,
+		},
+		{
+			"start=0&end=3600&step=15s&downsample=unknown", 0, true,
+		},
+		{
+			"start=0&end=3600&maxDataPoints=0", 0, true,
+		},
+	}
+
+	for _, c := range tc {
+		r := httptest.NewRequest("GET", "/?"+c.query, nil)
+		sr, err := parseSeriesRange(r)
+		if c.err {
+			if err == nil {
+				t.Errorf("parseSeriesRange(%q) should fail", c.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSeriesRange(%q) failed: %v", c.query, err)
+			continue
+		}
+		if sr.Step != c.step {
+			t.Errorf("parseSeriesRange(%q).Step = %v, want %v", c.query, sr.Step, c.step)
+		}
+	}
+}
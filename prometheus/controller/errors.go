@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// errorType mirrors the errorType of the Prometheus API, so the frontend could tell
+// the mistakes of user from the failures of backend.
+type errorType string
+
+const (
+	errorBadData     errorType = "bad_data"
+	errorTimeout     errorType = "timeout"
+	errorUnavailable errorType = "unavailable"
+	errorNotFound    errorType = "not_found"
+	errorConflict    errorType = "conflict"
+	errorExec        errorType = "execution"
+	errorInternal    errorType = "internal"
+)
+
+// errorStatusCode returns the HTTP status code of the result failed with typ.
+func errorStatusCode(typ errorType) int {
+	switch typ {
+	case errorBadData:
+		return http.StatusBadRequest
+	case errorNotFound:
+		return http.StatusNotFound
+	case errorConflict:
+		return http.StatusConflict
+	case errorExec:
+		return http.StatusUnprocessableEntity
+	case errorUnavailable:
+		return http.StatusServiceUnavailable
+	case errorTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// classifyError returns the errorType of err, which is returned by the cluster registry
+// the Prometheus API client or the Kubernetes API server.
+func classifyError(err error) errorType {
+	if err == errClusterNotFound || err == errRuleNotManaged {
+		return errorNotFound
+	}
+
+	if err == errKubernetesNotConfigured {
+		return errorUnavailable
+	}
+
+	if kerr, ok := err.(*kubeError); ok {
+		switch kerr.Code {
+		case http.StatusNotFound:
+			return errorNotFound
+		case http.StatusConflict:
+			return errorConflict
+		case http.StatusBadRequest, http.StatusUnprocessableEntity:
+			return errorBadData
+		case http.StatusUnauthorized, http.StatusForbidden:
+			// The credentials of the backend are misconfigured.
+			return errorInternal
+		default:
+			return errorUnavailable
+		}
+	}
+
+	if err == context.DeadlineExceeded || err == context.Canceled {
+		return errorTimeout
+	}
+
+	if apiErr, ok := err.(*apiv1.Error); ok {
+		switch apiErr.Type {
+		case apiv1.ErrBadData:
+			return errorBadData
+		case apiv1.ErrTimeout, apiv1.ErrCanceled:
+			return errorTimeout
+		case apiv1.ErrExec:
+			return errorExec
+		default:
+			// Prometheus responds with unexpected status code or body.
+			return errorUnavailable
+		}
+	}
+
+	// Connecting to Prometheus failed, *url.Error implements net.Error too.
+	if netErr, ok := err.(net.Error); ok {
+		if netErr.Timeout() {
+			return errorTimeout
+		}
+		return errorUnavailable
+	}
+
+	return errorInternal
+}
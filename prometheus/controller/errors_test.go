@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+func TestClassifyError(t *testing.T) {
+	tc := []struct {
+		err  error
+		typ  errorType
+		code int
+	}{
+		{
+			errClusterNotFound, errorNotFound, http.StatusNotFound,
+		},
+		{
+			context.DeadlineExceeded, errorTimeout, http.StatusGatewayTimeout,
+		},
+		{
+			&apiv1.Error{Type: apiv1.ErrBadData}, errorBadData, http.StatusBadRequest,
+		},
+		{
+			&apiv1.Error{Type: apiv1.ErrExec}, errorExec, http.StatusUnprocessableEntity,
+		},
+		{
+			&apiv1.Error{Type: apiv1.ErrServer}, errorUnavailable, http.StatusServiceUnavailable,
+		},
+		{
+			&url.Error{Op: "Get", URL: "http://ca:9090", Err: fmt.Errorf("connection refused")}, errorUnavailable, http.StatusServiceUnavailable,
+		},
+		{
+			&kubeError{Code: http.StatusConflict}, errorConflict, http.StatusConflict,
+		},
+		{
+			fmt.Errorf("unknown"), errorInternal, http.StatusInternalServerError,
+		},
+	}
+
+	for _, c := range tc {
+		typ := classifyError(c.err)
+		if typ != c.typ {
+			t.Errorf("classifyError(%v) = %q, want %q", c.err, typ, c.typ)
+		}
+		if code := errorStatusCode(typ); code != c.code {
+			t.Errorf("errorStatusCode(%q) = %d, want %d", typ, code, c.code)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/prometheus/common/model"
+)
+
+type exportFormat string
+
+const (
+	formatJSON        exportFormat = "json"
+	formatCSV         exportFormat = "csv"
+	formatOpenMetrics exportFormat = "openmetrics"
+
+	contentTypeCSV         = "text/csv; charset=utf-8"
+	contentTypeOpenMetrics = "application/openmetrics-text; version=0.0.1; charset=utf-8"
+
+	// flushRows is the number of rows written between the flushes of a stream.
+	flushRows = 1000
+)
+
+// parseExportFormat returns the format of the series responses, the format parameter
+// takes precedence over the Accept header.
+func parseExportFormat(r *http.Request) (exportFormat, error) {
+	if format := r.FormValue("format"); format != "" {
+		switch f := exportFormat(strings.ToLower(format)); f {
+		case formatJSON, formatCSV, formatOpenMetrics:
+			return f, nil
+		default:
+			return "", fmt.Errorf("unknown format %q, should be one of json, csv and openmetrics", format)
+		}
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		switch strings.TrimSpace(strings.SplitN(accept, ";", 2)[0]) {
+		case "application/json":
+			return formatJSON, nil
+		case "text/csv":
+			return formatCSV, nil
+		case "application/openmetrics-text":
+			return formatOpenMetrics, nil
+		}
+	}
+
+	return formatJSON, nil
+}
+
+// writeSeries writes result in format, only the successful result whose matrix
+// returns the series to export is written in the format other than JSON.
+func (p *PrometheusController) writeSeries(format exportFormat, result *queryResult, matrix func() model.Matrix) {
+	if format == formatJSON || result.Status != statusSuccess {
+		p.writeResult(result)
+		return
+	}
+
+	w := p.Ctx.ResponseWriter
+	var err error
+	switch format {
+	case formatCSV:
+		w.Header().Set("Content-Type", contentTypeCSV)
+		w.WriteHeader(http.StatusOK)
+		err = writeCSV(w, matrix())
+
+	case formatOpenMetrics:
+		w.Header().Set("Content-Type", contentTypeOpenMetrics)
+		w.WriteHeader(http.StatusOK)
+		err = writeOpenMetrics(w, matrix())
+	}
+
+	if err != nil {
+		logs.Error("Write %s response body failed: %v", format, err)
+	}
+}
+
+// flusher flushes the buffered rows to the client every flushRows rows, so the
+// response is streamed rather than fully buffered.
+type flusher struct {
+	w    io.Writer
+	bw   *bufio.Writer
+	rows int
+}
+
+func newFlusher(w io.Writer) *flusher {
+	return &flusher{w: w, bw: bufio.NewWriter(w)}
+}
+
+func (f *flusher) row() error {
+	f.rows++
+	if f.rows%flushRows != 0 {
+		return nil
+	}
+	return f.flush()
+}
+
+func (f *flusher) flush() error {
+	if err := f.bw.Flush(); err != nil {
+		return err
+	}
+	if hf, ok := f.w.(http.Flusher); ok {
+		hf.Flush()
+	}
+	return nil
+}
+
+func formatValue(v model.SampleValue) string {
+	f := float64(v)
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+// writeCSV writes a timestamp column and a column per series, the rows are merged by
+// timestamp from the sorted values of the series.
+func writeCSV(w io.Writer, matrix model.Matrix) error {
+	f := newFlusher(w)
+	cw := csv.NewWriter(f.bw)
+
+	header := []string{"timestamp"}
+	for _, ss := range matrix {
+		header = append(header, ss.Metric.String())
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	next := make([]int, len(matrix))
+	row := make([]string, len(matrix)+1)
+	for {
+		// The earliest timestamp not written yet.
+		ts := model.Latest
+		for i, ss := range matrix {
+			if next[i] < len(ss.Values) && ss.Values[next[i]].Timestamp.Before(ts) {
+				ts = ss.Values[next[i]].Timestamp
+			}
+		}
+		if ts == model.Latest {
+			break
+		}
+
+		row[0] = ts.Time().UTC().Format(time.RFC3339Nano)
+		for i, ss := range matrix {
+			row[i+1] = ""
+			if next[i] < len(ss.Values) && ss.Values[next[i]].Timestamp == ts {
+				row[i+1] = formatValue(ss.Values[next[i]].Value)
+				next[i]++
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+
+		if err := f.row(); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return f.flush()
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// writeOpenMetrics writes the OpenMetrics text exposition of matrix with the timestamps
+// of the samples, the series of the same metric name are written as a family of
+// unknown type.
+func writeOpenMetrics(w io.Writer, matrix model.Matrix) error {
+	f := newFlusher(w)
+
+	streams := make(model.Matrix, len(matrix))
+	copy(streams, matrix)
+	sort.SliceStable(streams, func(i, j int) bool {
+		return streams[i].Metric[model.MetricNameLabel] < streams[j].Metric[model.MetricNameLabel]
+	})
+
+	family := model.LabelValue("")
+	for _, ss := range streams {
+		name := ss.Metric[model.MetricNameLabel]
+		if name != family {
+			family = name
+			if _, err := fmt.Fprintf(f.bw, "# TYPE %s unknown\n", name); err != nil {
+				return err
+			}
+		}
+
+		names := make(model.LabelNames, 0, len(ss.Metric))
+		for ln := range ss.Metric {
+			if ln != model.MetricNameLabel {
+				names = append(names, ln)
+			}
+		}
+		sort.Sort(names)
+
+		var labels []string
+		for _, ln := range names {
+			labels = append(labels, fmt.Sprintf(`%s="%s"`, ln, labelValueReplacer.Replace(string(ss.Metric[ln]))))
+		}
+		metric := string(name)
+		if len(labels) != 0 {
+			metric += "{" + strings.Join(labels, ",") + "}"
+		}
+
+		for _, v := range ss.Values {
+			ts := float64(v.Timestamp) / 1000
+			if _, err := fmt.Fprintf(f.bw, "%s %s %s\n", metric, formatValue(v.Value), strconv.FormatFloat(ts, 'f', -1, 64)); err != nil {
+				return err
+			}
+			if err := f.row(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := io.WriteString(f.bw, "# EOF\n"); err != nil {
+		return err
+	}
+	return f.flush()
+}
+
+// exportName returns a valid metric name from name, which may be an expression.
+func exportName(name string) model.LabelValue {
+	var b strings.Builder
+	for i, c := range name {
+		if c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9' && i > 0) {
+			b.WriteRune(c)
+			continue
+		}
+		b.WriteByte('_')
+	}
+	if b.Len() == 0 {
+		return "series"
+	}
+	return model.LabelValue(b.String())
+}
+
+// podMatrix returns the series of QueryPod to export, named by their queries.
+func podMatrix(data *queryData) func() model.Matrix {
+	return func() model.Matrix {
+		matrix, _ := data.Result.(model.Matrix)
+		for _, ss := range matrix {
+			if _, ok := ss.Metric[model.MetricNameLabel]; !ok {
+				ss.Metric[model.MetricNameLabel] = exportName(string(ss.Metric["name"]))
+			}
+		}
+		return matrix
+	}
+}
+
+// seriesMatrix returns the series of QueryPodSeries to export, named by their metrics,
+// the failed ones are skipped.
+func seriesMatrix(data []*series) func() model.Matrix {
+	return func() model.Matrix {
+		matrix := model.Matrix{}
+		for _, s := range data {
+			if s.Error != "" {
+				logs.Warn("series %s failed, skipped in export: %s", s.Name, s.Error)
+				continue
+			}
+			for _, ss := range s.Result {
+				ss.Metric[model.MetricNameLabel] = exportName(s.Name)
+				// Tell the baseline series from the current ones.
+				if s.Tag != "" {
+					ss.Metric[tagLabel] = model.LabelValue(s.Tag)
+				}
+				matrix = append(matrix, ss)
+			}
+		}
+		return matrix
+	}
+}
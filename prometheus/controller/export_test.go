@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestParseExportFormat(t *testing.T) {
+	tc := []struct {
+		url    string
+		accept string
+		format exportFormat
+		err    bool
+	}{
+		{"/", "", formatJSON, false},
+		{"/", "text/csv", formatCSV, false},
+		{"/", "text/html, application/openmetrics-text; version=0.0.1", formatOpenMetrics, false},
+		{"/?format=CSV", "application/openmetrics-text", formatCSV, false},
+		{"/?format=xml", "", "", true},
+	}
+
+	for _, c := range tc {
+		r := httptest.NewRequest("GET", c.url, nil)
+		r.Header.Set("Accept", c.accept)
+		format, err := parseExportFormat(r)
+		if (err != nil) != c.err || format != c.format {
+			t.Errorf("%s with Accept %q: expected %q, got %q, error: %v", c.url, c.accept, c.format, format, err)
+		}
+	}
+}
+
+func testExportMatrix() model.Matrix {
+	return model.Matrix{
+		&model.SampleStream{
+			Metric: model.Metric{model.MetricNameLabel: "cpu", "container": "app"},
+			Values: []model.SamplePair{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}},
+		},
+		&model.SampleStream{
+			Metric: model.Metric{model.MetricNameLabel: "cpu", "container": "side\"car"},
+			Values: []model.SamplePair{{Timestamp: 2000, Value: 0.5}, {Timestamp: 3000, Value: model.SampleValue(math.NaN())}},
+		},
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeCSV(&b, testExportMatrix()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `timestamp,"cpu{container=""app""}","cpu{container=""side\""car""}"
+1970-01-01T00:00:01Z,1,
+1970-01-01T00:00:02Z,2,0.5
+1970-01-01T00:00:03Z,,NaN
+`
+	if b.String() != expected {
+		t.Errorf("expected CSV:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeOpenMetrics(&b, testExportMatrix()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# TYPE cpu unknown
+cpu{container="app"} 1 1
+cpu{container="app"} 2 2
+cpu{container="side\"car"} 0.5 2
+cpu{container="side\"car"} NaN 3
+# EOF
+`
+	if b.String() != expected {
+		t.Errorf("expected OpenMetrics:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// rangeQueryResult is the result of a single range query in a fan-out.
+type rangeQueryResult struct {
+	Matrix model.Matrix
+	Err    error
+}
+
+// queryRangeAll runs queries against client with at most concurrency queries in
+// flight. The i-th result always corresponds to the i-th query, the failure of a
+// query doesn't stop the others.
+func queryRangeAll(ctx context.Context, client API, queries []string, r apiv1.Range, concurrency int) []rangeQueryResult {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	results := make([]rangeQueryResult, len(queries))
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i, query := range queries {
+		wg.Add(1)
+		go func(i int, query string) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				results[i].Err = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+
+			value, err := client.QueryRange(ctx, query, r)
+			if err != nil {
+				results[i].Err = err
+				return
+			}
+			matrix, ok := value.(model.Matrix)
+			if !ok {
+				results[i].Err = fmt.Errorf("The type of QueryRange value is unexpected")
+				return
+			}
+			results[i].Matrix = matrix
+		}(i, query)
+	}
+	wg.Wait()
+
+	return results
+}
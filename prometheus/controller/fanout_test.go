@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// fakeAPI implements API, only the methods overridden could be called.
+type fakeAPI struct {
+	API
+
+	queryRange func(ctx context.Context, query string, r apiv1.Range) (model.Value, error)
+}
+
+func (f *fakeAPI) QueryRange(ctx context.Context, query string, r apiv1.Range) (model.Value, error) {
+	return f.queryRange(ctx, query, r)
+}
+
+func TestQueryRangeAll(t *testing.T) {
+	var inflight, maxInflight int32
+	client := &fakeAPI{
+		queryRange: func(ctx context.Context, query string, r apiv1.Range) (model.Value, error) {
+			n := atomic.AddInt32(&inflight, 1)
+			defer atomic.AddInt32(&inflight, -1)
+			for {
+				m := atomic.LoadInt32(&maxInflight)
+				if n <= m || atomic.CompareAndSwapInt32(&maxInflight, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			if query == "bad" {
+				return nil, fmt.Errorf("bad query")
+			}
+			return model.Matrix{
+				&model.SampleStream{Metric: model.Metric{model.MetricNameLabel: model.LabelValue(query)}},
+			}, nil
+		},
+	}
+
+	queries := []string{"a", "b", "bad", "c", "d", "e"}
+	results := queryRangeAll(context.Background(), client, queries, apiv1.Range{}, 2)
+
+	if maxInflight > 2 {
+		t.Errorf("max in-flight queries = %d, want at most 2", maxInflight)
+	}
+	for i, query := range queries {
+		if query == "bad" {
+			if results[i].Err == nil {
+				t.Errorf("result of %q should fail", query)
+			}
+			continue
+		}
+		if results[i].Err != nil {
+			t.Errorf("result of %q failed: %v", query, results[i].Err)
+			continue
+		}
+		if got := string(results[i].Matrix[0].Metric[model.MetricNameLabel]); got != query {
+			t.Errorf("result %d is of %q, want %q", i, got, query)
+		}
+	}
+}
+
+func TestQueryRangeAllTimeout(t *testing.T) {
+	client := &fakeAPI{
+		queryRange: func(ctx context.Context, query string, r apiv1.Range) (model.Value, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	for _, result := range queryRangeAll(ctx, client, []string{"a", "b", "c"}, apiv1.Range{}, 1) {
+		if result.Err != context.DeadlineExceeded {
+			t.Errorf("error = %v, want %v", result.Err, context.DeadlineExceeded)
+		}
+	}
+}
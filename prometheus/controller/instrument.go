@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/YaoZengzeng/practice/prometheus/metrics"
+
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// instrumentedAPI wraps API to observe the queries to the Prometheus of cluster.
+type instrumentedAPI struct {
+	API
+
+	cluster string
+}
+
+func (i *instrumentedAPI) observe(endpoint string) func(err error) {
+	inflight := metrics.UpstreamQueriesInFlight.WithLabelValues(i.cluster)
+	inflight.Inc()
+	start := time.Now()
+
+	return func(err error) {
+		inflight.Dec()
+		metrics.UpstreamQueryDuration.WithLabelValues(i.cluster, endpoint).Observe(time.Since(start).Seconds())
+		if err != nil {
+			metrics.UpstreamQueryErrors.WithLabelValues(i.cluster, endpoint, string(classifyError(err))).Inc()
+		}
+	}
+}
+
+func (i *instrumentedAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, error) {
+	done := i.observe("query")
+	value, err := i.API.Query(ctx, query, ts)
+	done(err)
+	return value, err
+}
+
+func (i *instrumentedAPI) QueryRange(ctx context.Context, query string, r apiv1.Range) (model.Value, error) {
+	done := i.observe("query_range")
+	value, err := i.API.QueryRange(ctx, query, r)
+	done(err)
+	return value, err
+}
+
+func (i *instrumentedAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, error) {
+	done := i.observe("series")
+	series, err := i.API.Series(ctx, matches, startTime, endTime)
+	done(err)
+	return series, err
+}
+
+func (i *instrumentedAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, error) {
+	done := i.observe("label_values")
+	values, err := i.API.LabelValues(ctx, label)
+	done(err)
+	return values, err
+}
+
+func (i *instrumentedAPI) TargetsMetadata(ctx context.Context, matchTarget string) ([]MetricMetadata, error) {
+	done := i.observe("targets_metadata")
+	metadata, err := i.API.TargetsMetadata(ctx, matchTarget)
+	done(err)
+	return metadata, err
+}
+
+func (i *instrumentedAPI) Alerts(ctx context.Context) (AlertsResult, error) {
+	done := i.observe("alerts")
+	alerts, err := i.API.Alerts(ctx)
+	done(err)
+	return alerts, err
+}
+
+func (i *instrumentedAPI) Rules(ctx context.Context) (RulesResult, error) {
+	done := i.observe("rules")
+	rules, err := i.API.Rules(ctx)
+	done(err)
+	return rules, err
+}
+
+// instrumentedStore wraps Store to observe its operations.
+type instrumentedStore struct {
+	Store
+}
+
+func observeStore(operation string) func(err error) {
+	start := time.Now()
+
+	return func(err error) {
+		metrics.StoreOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+		if err != nil {
+			metrics.StoreOperationErrors.WithLabelValues(operation).Inc()
+		}
+	}
+}
+
+func (i *instrumentedStore) GetPodMetricsRecords(id string) ([]string, error) {
+	done := observeStore("get")
+	records, err := i.Store.GetPodMetricsRecords(id)
+	done(err)
+	return records, err
+}
+
+func (i *instrumentedStore) AddPodMetricsRecords(id string, metrics []string) error {
+	done := observeStore("add")
+	err := i.Store.AddPodMetricsRecords(id, metrics)
+	done(err)
+	return err
+}
+
+func (i *instrumentedStore) DeletePodMetricsRecords(id string, metrics []string) error {
+	done := observeStore("delete")
+	err := i.Store.DeletePodMetricsRecords(id, metrics)
+	done(err)
+	return err
+}
+
+func (i *instrumentedStore) ResetPodMetricsRecords(id string, metrics []string) error {
+	done := observeStore("reset")
+	err := i.Store.ResetPodMetricsRecords(id, metrics)
+	done(err)
+	return err
+}
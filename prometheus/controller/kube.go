@@ -0,0 +1,328 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/YaoZengzeng/practice/prometheus/config"
+
+	"github.com/astaxie/beego/logs"
+)
+
+const (
+	serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// KubernetesSource is the API server of a cluster.
+type KubernetesSource = config.KubernetesSource
+
+// kubeDataSource converts ks to a DataSource to reuse its transport settings, the
+// in-cluster config of the backend is used if the url of ks is empty. The token of
+// the in-cluster config is rotated by the kubelet, so instead of the token of the
+// DataSource, the file of the token is returned to be reread.
+func kubeDataSource(ks KubernetesSource) (*DataSource, string, error) {
+	ds := &DataSource{
+		Url:                ks.Url,
+		Token:              ks.Token,
+		CAFile:             ks.CAFile,
+		CertFile:           ks.CertFile,
+		KeyFile:            ks.KeyFile,
+		InsecureSkipVerify: ks.InsecureSkipVerify,
+	}
+	if ds.Url != "" {
+		return ds, "", nil
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, "", errKubernetesNotConfigured
+	}
+
+	ds.Url = "https://" + net.JoinHostPort(host, port)
+	ds.CAFile = serviceAccountCAFile
+
+	return ds, serviceAccountTokenFile, nil
+}
+
+// kubeError is the failure Status returned by the API server.
+type kubeError struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+func (e *kubeError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reason, e.Message)
+}
+
+// RuleClient manages the PrometheusRules of a cluster.
+type RuleClient interface {
+	// List returns the PrometheusRules in namespace which have all the labels.
+	List(ctx context.Context, namespace string, labels map[string]string) ([]*PrometheusRule, error)
+
+	Get(ctx context.Context, namespace, name string) (*PrometheusRule, error)
+
+	Create(ctx context.Context, rule *PrometheusRule) (*PrometheusRule, error)
+
+	// Update replaces the PrometheusRule, it fails with a conflict if the
+	// resourceVersion of rule is not the latest.
+	Update(ctx context.Context, rule *PrometheusRule) (*PrometheusRule, error)
+
+	// Delete deletes the PrometheusRule if its resourceVersion is still
+	// resourceVersion, which is not checked if empty.
+	Delete(ctx context.Context, namespace, name, resourceVersion string) error
+}
+
+// KubeClientPool caches the KubeClients keyed by KubernetesSource like ClientPool.
+type KubeClientPool struct {
+	sync.Mutex
+	clients map[KubernetesSource]*KubeClient
+}
+
+func NewKubeClientPool() *KubeClientPool {
+	return &KubeClientPool{
+		clients: make(map[KubernetesSource]*KubeClient),
+	}
+}
+
+// Get returns the cached KubeClient of ks, a new one is created if there is none.
+func (c *KubeClientPool) Get(ks KubernetesSource) (*KubeClient, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if client, ok := c.clients[ks]; ok {
+		return client, nil
+	}
+
+	ds, tokenFile, err := kubeDataSource(ks)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newKubeClient(ds, tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	c.clients[ks] = client
+
+	return client, nil
+}
+
+// Invalidate removes the KubeClient of the API server of ds from the pool and closes
+// its idle connections.
+func (c *KubeClientPool) Invalidate(ds DataSource) {
+	c.Lock()
+	client, ok := c.clients[ds.Kubernetes]
+	delete(c.clients, ds.Kubernetes)
+	c.Unlock()
+
+	if ok {
+		client.transport.CloseIdleConnections()
+		logs.Info("client of Kubernetes %s invalidated", client.url)
+	}
+}
+
+// KubeClient sends JSON requests to the REST API of an API server.
+type KubeClient struct {
+	url       string
+	client    *http.Client
+	transport *http.Transport
+}
+
+func NewKubeClient(ds *DataSource) (*KubeClient, error) {
+	return newKubeClient(ds, "")
+}
+
+// newKubeClient creates the KubeClient of ds, which authenticates with the token in
+// tokenFile instead of the token of ds if tokenFile is not empty.
+func newKubeClient(ds *DataSource, tokenFile string) (*KubeClient, error) {
+	transport, err := newTransport(ds)
+	if err != nil {
+		return nil, err
+	}
+
+	var rt http.RoundTripper = transport
+	switch {
+	case tokenFile != "":
+		rt = &tokenFileRoundTripper{
+			file: tokenFile,
+			rt:   transport,
+		}
+	case ds.Token != "":
+		rt = &bearerAuthRoundTripper{
+			token: ds.Token,
+			rt:    transport,
+		}
+	}
+
+	return &KubeClient{
+		url:       strings.TrimRight(ds.Url, "/"),
+		client:    &http.Client{Transport: rt},
+		transport: transport,
+	}, nil
+}
+
+// Do sends in as the JSON body of the request to path and decodes the response into
+// out, the failure Status of the API server is returned as *kubeError.
+func (k *KubeClient) Do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, k.url+path, &body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		kerr := &kubeError{}
+		if err := json.Unmarshal(b, kerr); err != nil || kerr.Code == 0 {
+			kerr = &kubeError{Code: resp.StatusCode, Reason: resp.Status, Message: string(b)}
+		}
+		return kerr
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+// RuleClientPool caches the RuleClients keyed by KubernetesSource.
+type RuleClientPool struct {
+	sync.Mutex
+	kube    *KubeClientPool
+	clients map[KubernetesSource]RuleClient
+}
+
+func NewRuleClientPool(kube *KubeClientPool) *RuleClientPool {
+	return &RuleClientPool{
+		kube:    kube,
+		clients: make(map[KubernetesSource]RuleClient),
+	}
+}
+
+// Get returns the cached RuleClient of ks, a new one is created if there is none.
+func (c *RuleClientPool) Get(ks KubernetesSource) (RuleClient, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if client, ok := c.clients[ks]; ok {
+		return client, nil
+	}
+
+	kube, err := c.kube.Get(ks)
+	if err != nil {
+		return nil, err
+	}
+	client := &kubeRuleClient{kube}
+	c.clients[ks] = client
+
+	return client, nil
+}
+
+// Invalidate removes the RuleClient of the API server of ds from the pool.
+func (c *RuleClientPool) Invalidate(ds DataSource) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.clients, ds.Kubernetes)
+}
+
+// kubeRuleClient implements RuleClient with the REST API of the API server.
+type kubeRuleClient struct {
+	kube *KubeClient
+}
+
+func (k *kubeRuleClient) path(namespace, name string) string {
+	p := fmt.Sprintf("/apis/%s/namespaces/%s/prometheusrules", prometheusRuleAPIVersion, url.PathEscape(namespace))
+	if name != "" {
+		p += "/" + url.PathEscape(name)
+	}
+	return p
+}
+
+func (k *kubeRuleClient) List(ctx context.Context, namespace string, labels map[string]string) ([]*PrometheusRule, error) {
+	var selector []string
+	for name, value := range labels {
+		selector = append(selector, name+"="+value)
+	}
+
+	u := k.path(namespace, "")
+	if len(selector) != 0 {
+		u += "?" + url.Values{"labelSelector": {strings.Join(selector, ",")}}.Encode()
+	}
+
+	var list struct {
+		Items []*PrometheusRule `json:"items"`
+	}
+	if err := k.kube.Do(ctx, http.MethodGet, u, nil, &list); err != nil {
+		return nil, err
+	}
+
+	return list.Items, nil
+}
+
+func (k *kubeRuleClient) Get(ctx context.Context, namespace, name string) (*PrometheusRule, error) {
+	rule := &PrometheusRule{}
+	if err := k.kube.Do(ctx, http.MethodGet, k.path(namespace, name), nil, rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (k *kubeRuleClient) Create(ctx context.Context, rule *PrometheusRule) (*PrometheusRule, error) {
+	created := &PrometheusRule{}
+	if err := k.kube.Do(ctx, http.MethodPost, k.path(rule.Metadata.Namespace, ""), rule, created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (k *kubeRuleClient) Update(ctx context.Context, rule *PrometheusRule) (*PrometheusRule, error) {
+	updated := &PrometheusRule{}
+	if err := k.kube.Do(ctx, http.MethodPut, k.path(rule.Metadata.Namespace, rule.Metadata.Name), rule, updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (k *kubeRuleClient) Delete(ctx context.Context, namespace, name, resourceVersion string) error {
+	options := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "DeleteOptions",
+	}
+	if resourceVersion != "" {
+		options["preconditions"] = map[string]string{"resourceVersion": resourceVersion}
+	}
+
+	return k.kube.Do(ctx, http.MethodDelete, k.path(namespace, name), options, nil)
+}
@@ -0,0 +1,229 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/YaoZengzeng/practice/prometheus/config"
+	"github.com/YaoZengzeng/practice/prometheus/promql"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/prometheus/common/model"
+)
+
+// defaultLabelsWindow is the time range to look for label values if not specified.
+const defaultLabelsWindow = time.Hour
+
+// labelsWindow parses the optional start and end form values of r, which bound the
+// series to look for label values. They are truncated to minute so that the requests
+// in the same minute could share the cached response.
+func labelsWindow(r *http.Request) (time.Time, time.Time, error) {
+	end := time.Now()
+	if s := r.FormValue("end"); s != "" {
+		t, err := parseTime(s)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("Parse end time failed: %v", err)
+		}
+		end = t
+	}
+
+	start := end.Add(-defaultLabelsWindow)
+	if s := r.FormValue("start"); s != "" {
+		t, err := parseTime(s)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("Parse start time failed: %v", err)
+		}
+		start = t
+	}
+
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("End before start")
+	}
+
+	return start.Truncate(time.Minute), end.Truncate(time.Minute), nil
+}
+
+// querySeries returns the label sets of the series matching match in the time window
+// of the request, the responses are cached for the label_ttl of the cache config.
+func (p *PrometheusController) querySeries(cluster, match string) ([]model.LabelSet, *queryResult) {
+	start, end, err := labelsWindow(p.Ctx.Request)
+	if err != nil {
+		return nil, &queryResult{
+			Status:    statusError,
+			ErrorType: errorBadData,
+			Error:     err.Error(),
+		}
+	}
+
+	key := fmt.Sprintf("%s|%s|%d|%d", cluster, match, start.Unix(), end.Unix())
+	if cached, ok := p.LabelCache.get(key); ok {
+		return cached.([]model.LabelSet), nil
+	}
+
+	client, err := p.getClusterClient(cluster)
+	if err != nil {
+		return nil, &queryResult{
+			Status:    statusError,
+			ErrorType: classifyError(err),
+			Error:     fmt.Sprintf("Get Prometheus client failed: %v", err),
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(p.Ctx.Request.Context(), config.Get().Query.Timeout)
+	defer cancel()
+
+	series, err := client.Series(ctx, []string{match}, start, end)
+	if err != nil {
+		return nil, &queryResult{
+			Status:    statusError,
+			ErrorType: classifyError(err),
+			Error:     fmt.Sprintf("Query series failed: %v", err),
+		}
+	}
+
+	p.LabelCache.set(key, series)
+
+	return series, nil
+}
+
+// distinctValues returns the sorted distinct values of label in series.
+func distinctValues(series []model.LabelSet, label model.LabelName) []string {
+	unique := make(map[string]struct{})
+	for _, s := range series {
+		if v, ok := s[label]; ok && v != "" {
+			unique[string(v)] = struct{}{}
+		}
+	}
+
+	values := []string{}
+	for v := range unique {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+
+	return values
+}
+
+func (p *PrometheusController) QueryNamespaces() *queryResult {
+	cluster := p.GetString(":cluster")
+	logs.Info("cluster: %s", cluster)
+
+	// Every target has the "up" series, which is much cheaper than all the series.
+	labels := p.podLabels(cluster)
+	match := promql.NewSelector("up", &promql.Matcher{Type: promql.MatchNotEqual, Name: labels.Namespace}).String()
+	series, result := p.querySeries(cluster, match)
+	if result != nil {
+		return result
+	}
+
+	return &queryResult{
+		Status: statusSuccess,
+		Data:   distinctValues(series, model.LabelName(labels.Namespace)),
+	}
+}
+
+func (p *PrometheusController) Namespaces() {
+	p.writeResult(p.QueryNamespaces())
+}
+
+func (p *PrometheusController) QueryPods() *queryResult {
+	cluster := p.GetString(":cluster")
+	namespace := p.GetString(":namespace")
+	logs.Info("cluster: %s, namespace: %s", cluster, namespace)
+
+	labels := p.podLabels(cluster)
+	match := promql.NewSelector("up",
+		promql.NewEqualMatcher(labels.Namespace, namespace),
+		&promql.Matcher{Type: promql.MatchNotEqual, Name: labels.PodName},
+	).String()
+	series, result := p.querySeries(cluster, match)
+	if result != nil {
+		return result
+	}
+
+	return &queryResult{
+		Status: statusSuccess,
+		Data:   distinctValues(series, model.LabelName(labels.PodName)),
+	}
+}
+
+func (p *PrometheusController) Pods() {
+	p.writeResult(p.QueryPods())
+}
+
+func (p *PrometheusController) QueryPodLabelValues() *queryResult {
+	cluster := p.GetString(":cluster")
+	namespace := p.GetString(":namespace")
+	pod := p.GetString(":pod")
+	label := p.GetString(":label")
+	logs.Info("cluster: %s, namespace: %s, pod: %s, label: %s", cluster, namespace, pod, label)
+
+	if !promql.IsLabelName(label) {
+		return &queryResult{
+			Status:    statusError,
+			ErrorType: errorBadData,
+			Error:     fmt.Sprintf("Invalid label name %q", label),
+		}
+	}
+
+	match := promql.NewSelector("", podMatchers(p.podLabels(cluster), namespace, pod)...).String()
+	series, result := p.querySeries(cluster, match)
+	if result != nil {
+		return result
+	}
+
+	return &queryResult{
+		Status: statusSuccess,
+		Data:   distinctValues(series, model.LabelName(label)),
+	}
+}
+
+func (p *PrometheusController) PodLabelValues() {
+	p.writeResult(p.QueryPodLabelValues())
+}
+
+func (p *PrometheusController) QueryPodMetricNames() *queryResult {
+	cluster := p.GetString(":cluster")
+	namespace := p.GetString(":namespace")
+	pod := p.GetString(":pod")
+	logs.Info("cluster: %s, namespace: %s, pod: %s", cluster, namespace, pod)
+
+	match := promql.NewSelector("", podMatchers(p.podLabels(cluster), namespace, pod)...).String()
+	series, result := p.querySeries(cluster, match)
+	if result != nil {
+		return result
+	}
+
+	client, err := p.getClusterClient(cluster)
+	if err != nil {
+		return &queryResult{
+			Status:    statusError,
+			ErrorType: classifyError(err),
+			Error:     fmt.Sprintf("Get Prometheus client failed: %v", err),
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(p.Ctx.Request.Context(), config.Get().Query.Timeout)
+	defer cancel()
+
+	metadata, err := client.TargetsMetadata(ctx, match)
+	if err != nil {
+		return &queryResult{
+			Status:    statusError,
+			ErrorType: classifyError(err),
+			Error:     fmt.Sprintf("Get targets metadata failed: %v", err),
+		}
+	}
+
+	return &queryResult{
+		Status: statusSuccess,
+		Data:   newMetricList(metadata, distinctValues(series, model.MetricNameLabel), match),
+	}
+}
+
+func (p *PrometheusController) PodMetricNames() {
+	p.writeResult(p.QueryPodMetricNames())
+}
@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// metricInfo describes a metric of the pod, Query is the recommended PromQL to chart it.
+type metricInfo struct {
+	Name  string `json:"name"`
+	Help  string `json:"help,omitempty"`
+	Unit  string `json:"unit,omitempty"`
+	Query string `json:"query"`
+}
+
+type metricList struct {
+	Counter   []*metricInfo `json:"counter"`
+	Gauge     []*metricInfo `json:"gauge"`
+	Summary   []*metricInfo `json:"summary"`
+	Histogram []*metricInfo `json:"histogram"`
+	// Unknown contains the untyped metrics and the ones without metadata.
+	Unknown []*metricInfo `json:"unknown"`
+}
+
+// metricSuffixes are appended to the name of summary or histogram for its series.
+var metricSuffixes = []string{"_sum", "_count", "_bucket"}
+
+// metricQuery returns the recommended PromQL to chart the metric of typ, selector is
+// the label matchers in braces to select the samples of the pod.
+func metricQuery(name, typ, selector string) string {
+	switch typ {
+	case "counter":
+		return fmt.Sprintf("rate(%s%s[5m])", name, selector)
+
+	case "summary":
+		return fmt.Sprintf("rate(%[1]s_sum%[2]s[5m]) / rate(%[1]s_count%[2]s[5m])", name, selector)
+
+	case "histogram":
+		return fmt.Sprintf("histogram_quantile(0.99, sum(rate(%s_bucket%s[5m])) by (le))", name, selector)
+
+	default:
+		return name + selector
+	}
+}
+
+// newMetricList groups the metrics by type. If names is nil, all the metrics in metadata
+// are listed, otherwise only the ones in names are listed and the names without
+// metadata are listed as unknown. The metrics are deduplicated since the same metric
+// could be exposed by several targets of the pod.
+func newMetricList(metadata []MetricMetadata, names []string, selector string) *metricList {
+	index := make(map[string]*MetricMetadata)
+	for i := range metadata {
+		m := &metadata[i]
+		if prev, ok := index[m.Metric]; ok {
+			// Keep the first one, but fill its blank fields.
+			if prev.Help == "" {
+				prev.Help = m.Help
+			}
+			if prev.Unit == "" {
+				prev.Unit = m.Unit
+			}
+			continue
+		}
+		index[m.Metric] = m
+	}
+
+	if names == nil {
+		for name := range index {
+			names = append(names, name)
+		}
+	}
+
+	mlist := &metricList{}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		m, ok := index[name]
+		if !ok {
+			// The series of summaries and histograms are named with suffixes.
+			for _, suffix := range metricSuffixes {
+				if strings.HasSuffix(name, suffix) {
+					if m, ok = index[strings.TrimSuffix(name, suffix)]; ok {
+						break
+					}
+				}
+			}
+		}
+
+		info := &metricInfo{
+			Name: name,
+		}
+		typ := ""
+		if m != nil {
+			info.Name = m.Metric
+			info.Help = m.Help
+			info.Unit = m.Unit
+			typ = m.Type
+		}
+
+		if seen[info.Name] {
+			continue
+		}
+		seen[info.Name] = true
+		info.Query = metricQuery(info.Name, typ, selector)
+
+		switch typ {
+		case "counter":
+			mlist.Counter = append(mlist.Counter, info)
+
+		case "gauge":
+			mlist.Gauge = append(mlist.Gauge, info)
+
+		case "summary":
+			mlist.Summary = append(mlist.Summary, info)
+
+		case "histogram":
+			mlist.Histogram = append(mlist.Histogram, info)
+
+		default:
+			mlist.Unknown = append(mlist.Unknown, info)
+		}
+	}
+
+	for _, infos := range [][]*metricInfo{mlist.Counter, mlist.Gauge, mlist.Summary, mlist.Histogram, mlist.Unknown} {
+		sort.Slice(infos, func(i, j int) bool {
+			return infos[i].Name < infos[j].Name
+		})
+	}
+
+	return mlist
+}
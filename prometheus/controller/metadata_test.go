@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"testing"
+)
+
+func TestNewMetricList(t *testing.T) {
+	metadata := []MetricMetadata{
+		{Metric: "http_requests_total", Type: "counter"},
+		{Metric: "http_requests_total", Type: "counter", Help: "Total HTTP requests."},
+		{Metric: "memory_bytes", Type: "gauge", Unit: "bytes"},
+		{Metric: "latency_seconds", Type: "histogram"},
+		{Metric: "legacy", Type: "untyped"},
+	}
+	selector := `{kubernetes_pod_name="pod"}`
+
+	mlist := newMetricList(metadata, nil, selector)
+	if len(mlist.Counter) != 1 || mlist.Counter[0].Help != "Total HTTP requests." {
+		t.Errorf("counters = %v, want one with help", mlist.Counter)
+	}
+	if got, want := mlist.Counter[0].Query, `rate(http_requests_total{kubernetes_pod_name="pod"}[5m])`; got != want {
+		t.Errorf("counter query = %q, want %q", got, want)
+	}
+	if len(mlist.Gauge) != 1 || mlist.Gauge[0].Unit != "bytes" {
+		t.Errorf("gauges = %v, want one with unit", mlist.Gauge)
+	}
+	if got, want := mlist.Histogram[0].Query, `histogram_quantile(0.99, sum(rate(latency_seconds_bucket{kubernetes_pod_name="pod"}[5m])) by (le))`; got != want {
+		t.Errorf("histogram query = %q, want %q", got, want)
+	}
+	if len(mlist.Unknown) != 1 || mlist.Unknown[0].Name != "legacy" {
+		t.Errorf("unknown = %v, want legacy", mlist.Unknown)
+	}
+
+	// Series names are mapped to their metric families, the ones without metadata are unknown.
+	mlist = newMetricList(metadata, []string{"latency_seconds_bucket", "latency_seconds_count", "recorded:rate5m"}, selector)
+	if len(mlist.Histogram) != 1 || mlist.Histogram[0].Name != "latency_seconds" {
+		t.Errorf("histograms = %v, want latency_seconds", mlist.Histogram)
+	}
+	if len(mlist.Unknown) != 1 || mlist.Unknown[0].Name != "recorded:rate5m" {
+		t.Errorf("unknown = %v, want recorded:rate5m", mlist.Unknown)
+	}
+	if len(mlist.Counter) != 0 {
+		t.Errorf("counters = %v, want none", mlist.Counter)
+	}
+}
@@ -0,0 +1,271 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	api "github.com/prometheus/client_golang/api"
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	epTargetsMetadata = "/api/v1/targets/metadata"
+	epAlerts          = "/api/v1/alerts"
+	epRules           = "/api/v1/rules"
+)
+
+// API is the Prometheus v1 API with the endpoints which are missing in the vendored
+// client. The types are modeled after the newer versions of client_golang, so they
+// could be replaced once it's upgraded.
+type API interface {
+	apiv1.API
+
+	// TargetsMetadata returns metadata about metrics currently scraped by the targets
+	// matching matchTarget.
+	TargetsMetadata(ctx context.Context, matchTarget string) ([]MetricMetadata, error)
+	// Alerts returns a list of all active alerts.
+	Alerts(ctx context.Context) (AlertsResult, error)
+	// Rules returns a list of alerting and recording rules that are currently loaded.
+	Rules(ctx context.Context) (RulesResult, error)
+}
+
+// MetricMetadata contains metadata of a metric.
+type MetricMetadata struct {
+	Metric string `json:"metric"`
+	Type   string `json:"type"`
+	Help   string `json:"help"`
+	Unit   string `json:"unit"`
+}
+
+// AlertState models the state of an alert.
+type AlertState string
+
+// RuleType models the type of a rule.
+type RuleType string
+
+// RuleHealth models the health status of a rule.
+type RuleHealth string
+
+const (
+	AlertStateFiring   AlertState = "firing"
+	AlertStateInactive AlertState = "inactive"
+	AlertStatePending  AlertState = "pending"
+
+	RuleTypeRecording RuleType = "recording"
+	RuleTypeAlerting  RuleType = "alerting"
+
+	RuleHealthGood    RuleHealth = "ok"
+	RuleHealthUnknown RuleHealth = "unknown"
+	RuleHealthBad     RuleHealth = "err"
+)
+
+// AlertsResult contains the result from querying the alerts endpoint.
+type AlertsResult struct {
+	Alerts []Alert `json:"alerts"`
+}
+
+// Alert models an active alert.
+type Alert struct {
+	ActiveAt    time.Time      `json:"activeAt"`
+	Annotations model.LabelSet `json:"annotations"`
+	Labels      model.LabelSet `json:"labels"`
+	State       AlertState     `json:"state"`
+	Value       string         `json:"value"`
+}
+
+// RulesResult contains the result from querying the rules endpoint.
+type RulesResult struct {
+	Groups []LoadedRuleGroup `json:"groups"`
+}
+
+// LoadedRuleGroup models a rule group loaded by Prometheus that contains a set of
+// recording and alerting rules, unlike RuleGroup which is a group of a PrometheusRule.
+type LoadedRuleGroup struct {
+	Name     string  `json:"name"`
+	File     string  `json:"file"`
+	Interval float64 `json:"interval"`
+	Rules    Rules   `json:"rules"`
+}
+
+// Rules is a list of AlertingRule and RecordingRule.
+type Rules []interface{}
+
+// AlertingRule models an alerting rule.
+type AlertingRule struct {
+	Name        string         `json:"name"`
+	Query       string         `json:"query"`
+	Duration    float64        `json:"duration"`
+	Labels      model.LabelSet `json:"labels"`
+	Annotations model.LabelSet `json:"annotations"`
+	Alerts      []*Alert       `json:"alerts"`
+	Health      RuleHealth     `json:"health"`
+	LastError   string         `json:"lastError,omitempty"`
+}
+
+// RecordingRule models a recording rule.
+type RecordingRule struct {
+	Name      string         `json:"name"`
+	Query     string         `json:"query"`
+	Labels    model.LabelSet `json:"labels,omitempty"`
+	Health    RuleHealth     `json:"health"`
+	LastError string         `json:"lastError,omitempty"`
+}
+
+// UnmarshalJSON decodes the rules of the group by their types.
+func (rg *LoadedRuleGroup) UnmarshalJSON(b []byte) error {
+	v := struct {
+		Name     string            `json:"name"`
+		File     string            `json:"file"`
+		Interval float64           `json:"interval"`
+		Rules    []json.RawMessage `json:"rules"`
+	}{}
+
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	rg.Name = v.Name
+	rg.File = v.File
+	rg.Interval = v.Interval
+
+	for _, rule := range v.Rules {
+		var typ struct {
+			Type RuleType `json:"type"`
+		}
+		if err := json.Unmarshal(rule, &typ); err != nil {
+			return err
+		}
+
+		switch typ.Type {
+		case RuleTypeAlerting:
+			var alertingRule AlertingRule
+			if err := json.Unmarshal(rule, &alertingRule); err != nil {
+				return err
+			}
+			rg.Rules = append(rg.Rules, alertingRule)
+		case RuleTypeRecording:
+			var recordingRule RecordingRule
+			if err := json.Unmarshal(rule, &recordingRule); err != nil {
+				return err
+			}
+			rg.Rules = append(rg.Rules, recordingRule)
+		default:
+			return fmt.Errorf("failed to unmarshal rule: unknown type %q", typ.Type)
+		}
+	}
+
+	return nil
+}
+
+// httpAPI implements API with the vendored client, the missing endpoints are called
+// through api.Client directly.
+type httpAPI struct {
+	apiv1.API
+
+	client api.Client
+}
+
+// NewAPI returns a new API for the client.
+func NewAPI(client api.Client) API {
+	return &httpAPI{
+		API:    apiv1.NewAPI(client),
+		client: client,
+	}
+}
+
+func (h *httpAPI) TargetsMetadata(ctx context.Context, matchTarget string) ([]MetricMetadata, error) {
+	var res []MetricMetadata
+	err := h.get(ctx, epTargetsMetadata, url.Values{"match_target": {matchTarget}}, &res)
+	return res, err
+}
+
+func (h *httpAPI) Alerts(ctx context.Context) (AlertsResult, error) {
+	var res AlertsResult
+	err := h.get(ctx, epAlerts, nil, &res)
+	return res, err
+}
+
+func (h *httpAPI) Rules(ctx context.Context) (RulesResult, error) {
+	var res RulesResult
+	err := h.get(ctx, epRules, nil, &res)
+	return res, err
+}
+
+// apiResponse is the envelope of the responses of the Prometheus API.
+type apiResponse struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	ErrorType apiv1.ErrorType `json:"errorType"`
+	Error     string          `json:"error"`
+}
+
+// get sends a GET request to the endpoint ep and decodes the data of the response into
+// v. The errors are returned as *apiv1.Error like the vendored client does, so they
+// are classified the same way.
+func (h *httpAPI) get(ctx context.Context, ep string, args url.Values, v interface{}) error {
+	u := h.client.URL(ep, nil)
+	u.RawQuery = args.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, body, err := h.client.Do(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	// Prometheus returns the errors of the API with these codes.
+	code := resp.StatusCode
+	apiError := code == http.StatusUnprocessableEntity || code == http.StatusBadRequest
+
+	if code/100 != 2 && !apiError {
+		errorType := apiv1.ErrBadResponse
+		switch code / 100 {
+		case 4:
+			errorType = apiv1.ErrClient
+		case 5:
+			errorType = apiv1.ErrServer
+		}
+		return &apiv1.Error{
+			Type:   errorType,
+			Msg:    fmt.Sprintf("server returned HTTP status %s", resp.Status),
+			Detail: string(body),
+		}
+	}
+
+	var result apiResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return &apiv1.Error{
+			Type: apiv1.ErrBadResponse,
+			Msg:  err.Error(),
+		}
+	}
+
+	if apiError != (result.Status == "error") {
+		return &apiv1.Error{
+			Type: apiv1.ErrBadResponse,
+			Msg:  "inconsistent body for response code",
+		}
+	}
+	if apiError {
+		return &apiv1.Error{
+			Type: result.ErrorType,
+			Msg:  result.Error,
+		}
+	}
+
+	if err := json.Unmarshal(result.Data, v); err != nil {
+		return &apiv1.Error{
+			Type: apiv1.ErrBadResponse,
+			Msg:  err.Error(),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	api "github.com/prometheus/client_golang/api"
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+func TestAPI(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case epTargetsMetadata:
+			if r.FormValue("match_target") != `{job="node"}` {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"unexpected match_target"}`))
+				return
+			}
+			w.Write([]byte(`{"status":"success","data":[{"metric":"up","type":"gauge","help":"Up.","unit":""}]}`))
+		case epAlerts:
+			w.Write([]byte(`{"status":"success","data":{"alerts":[{"labels":{"alertname":"Down"},"state":"firing","value":"1"}]}}`))
+		case epRules:
+			w.Write([]byte(`{"status":"success","data":{"groups":[{"name":"g","rules":[
+				{"type":"alerting","name":"Down","query":"up == 0","health":"ok"},
+				{"type":"recording","name":"job:up:sum","query":"sum(up) by (job)","health":"ok"}
+			]}]}}`))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	client, err := api.NewClient(api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAPI(client)
+	ctx := context.Background()
+
+	metadata, err := a.TargetsMetadata(ctx, `{job="node"}`)
+	if err != nil {
+		t.Fatalf("TargetsMetadata failed: %v", err)
+	}
+	if len(metadata) != 1 || metadata[0].Help != "Up." {
+		t.Errorf("TargetsMetadata = %v, want the metadata of up", metadata)
+	}
+
+	_, err = a.TargetsMetadata(ctx, `{job="other"}`)
+	if apiErr, ok := err.(*apiv1.Error); !ok || apiErr.Type != apiv1.ErrBadData {
+		t.Errorf("TargetsMetadata error = %v, want bad_data", err)
+	}
+
+	alerts, err := a.Alerts(ctx)
+	if err != nil {
+		t.Fatalf("Alerts failed: %v", err)
+	}
+	if len(alerts.Alerts) != 1 || alerts.Alerts[0].State != AlertStateFiring {
+		t.Errorf("Alerts = %v, want a firing alert", alerts)
+	}
+
+	rules, err := a.Rules(ctx)
+	if err != nil {
+		t.Fatalf("Rules failed: %v", err)
+	}
+	if len(rules.Groups) != 1 || len(rules.Groups[0].Rules) != 2 {
+		t.Fatalf("Rules = %v, want a group of 2 rules", rules)
+	}
+	if _, ok := rules.Groups[0].Rules[0].(AlertingRule); !ok {
+		t.Errorf("first rule is %T, want AlertingRule", rules.Groups[0].Rules[0])
+	}
+	if _, ok := rules.Groups[0].Rules[1].(RecordingRule); !ok {
+		t.Errorf("second rule is %T, want RecordingRule", rules.Groups[0].Rules[1])
+	}
+
+	// The endpoints of the vendored client are still served.
+	if _, err := a.LabelValues(ctx, "job"); err == nil {
+		t.Errorf("LabelValues should fail with the server error")
+	} else if apiErr, ok := err.(*apiv1.Error); !ok || apiErr.Type != apiv1.ErrServer {
+		t.Errorf("LabelValues error = %v, want server_error", err)
+	}
+}
@@ -0,0 +1,194 @@
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+
+	"github.com/YaoZengzeng/practice/prometheus/config"
+	"github.com/YaoZengzeng/practice/prometheus/promql"
+
+	"github.com/astaxie/beego/logs"
+	"gopkg.in/yaml.v2"
+)
+
+// namedQuery is a PromQL template with a name to let frontend know the meaning of
+// corresponding samples. Every "%[1]s" (or the single "%s") in the template is replaced
+// by the label matchers selecting the monitored object.
+type namedQuery struct {
+	Name  string `yaml:"name"`
+	Query string `yaml:"query"`
+
+	// Labels override the names of the labels which identify the pod for the query,
+	// e.g. kube-state-metrics exports the pod in its own namespace and pod labels,
+	// while the labels set by the scrape identify the kube-state-metrics pod.
+	Labels config.LabelsConfig `yaml:"labels"`
+}
+
+// placeholderProbe replaces the placeholder to validate the templates.
+const placeholderProbe = `__placeholder__="probe"`
+
+// validate checks the name and the template of q, the template must be a valid
+// expression once its placeholders are replaced.
+func (q *namedQuery) validate() error {
+	if q.Name == "" {
+		return fmt.Errorf("pod query %q has no name", q.Query)
+	}
+	if err := q.Labels.Validate(); err != nil {
+		return fmt.Errorf("pod query %q labels: %v", q.Name, err)
+	}
+
+	// The unused matchers are rendered as "%!(EXTRA ...)", and any placeholder which
+	// is not replaced, like the second one of two bare "%s", as "%!s(MISSING)".
+	query := fmt.Sprintf(q.Query, placeholderProbe)
+	if strings.Contains(query, "%!(EXTRA") {
+		return fmt.Errorf("pod query %q has no placeholder of label matchers", q.Name)
+	}
+	if strings.Contains(query, "%!") {
+		return fmt.Errorf("pod query %q has invalid placeholders, use %%[1]s to repeat the label matchers", q.Name)
+	}
+	if err := promql.Validate(query); err != nil {
+		return fmt.Errorf("pod query %q: %v", q.Name, err)
+	}
+	return nil
+}
+
+// nodeQueries are built from the metrics of node-exporter, which is deployed as a
+// DaemonSet with host network, so the instance label of its samples is the node address.
+var nodeQueries = []namedQuery{
+	{
+		Name:  "cpu_usage",
+		Query: `1 - avg(rate(node_cpu_seconds_total{mode="idle", %s}[5m]))`,
+	},
+	{
+		Name:  "memory_usage",
+		Query: `1 - sum(node_memory_MemAvailable_bytes{%[1]s}) / sum(node_memory_MemTotal_bytes{%[1]s})`,
+	},
+	{
+		Name:  "filesystem_usage",
+		Query: `1 - sum(node_filesystem_avail_bytes{fstype!~"tmpfs|rootfs", %[1]s}) / sum(node_filesystem_size_bytes{fstype!~"tmpfs|rootfs", %[1]s})`,
+	},
+	{
+		Name:  "network_receive_bytes",
+		Query: `sum(rate(node_network_receive_bytes_total{device!="lo", %s}[5m]))`,
+	},
+	{
+		Name:  "network_transmit_bytes",
+		Query: `sum(rate(node_network_transmit_bytes_total{device!="lo", %s}[5m]))`,
+	},
+}
+
+// defaultPodQueries are built from the metrics of cAdvisor and kube-state-metrics, they
+// are used when no pod queries file is specified. Both export the pod in their own
+// namespace and pod labels. The series of cAdvisor without container are of the cgroup
+// of the pod, and those of container "POD" are of the pause container, which are not
+// summed with the containers.
+var defaultPodQueries = []namedQuery{
+	{
+		Name:   "cpu_usage",
+		Query:  `sum(rate(container_cpu_usage_seconds_total{container!="", container!="POD", %s}[5m]))`,
+		Labels: kubeletLabels,
+	},
+	{
+		Name:   "memory_working_set_bytes",
+		Query:  `sum(container_memory_working_set_bytes{container!="", container!="POD", %s})`,
+		Labels: kubeletLabels,
+	},
+	{
+		Name:   "network_receive_bytes",
+		Query:  `sum(rate(container_network_receive_bytes_total{%s}[5m]))`,
+		Labels: kubeletLabels,
+	},
+	{
+		Name:   "network_transmit_bytes",
+		Query:  `sum(rate(container_network_transmit_bytes_total{%s}[5m]))`,
+		Labels: kubeletLabels,
+	},
+	{
+		Name:   "restarts",
+		Query:  `sum(kube_pod_container_status_restarts_total{%s})`,
+		Labels: kubeletLabels,
+	},
+}
+
+// kubeletLabels are the names of the labels of the pod in the metrics of cAdvisor and
+// kube-state-metrics.
+var kubeletLabels = config.LabelsConfig{
+	Namespace: "namespace",
+	PodName:   "pod",
+}
+
+// PodQueries holds the queries used to monitor a pod, they are loaded from the pod
+// queries file once the config is loaded or reloaded.
+type PodQueries struct {
+	queries atomic.Value
+}
+
+// NewPodQueries returns the pod queries loaded from filename, or the default ones if
+// filename is empty.
+func NewPodQueries(filename string) (*PodQueries, error) {
+	queries, err := loadPodQueries(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &PodQueries{}
+	q.queries.Store(queries)
+	return q, nil
+}
+
+// Get returns the current pod queries.
+func (q *PodQueries) Get() []namedQuery {
+	return q.queries.Load().([]namedQuery)
+}
+
+// ConfigReloaded loads the pod queries file of new, the current queries are kept if
+// the file is invalid.
+func (q *PodQueries) ConfigReloaded(old, new *config.Config) {
+	queries, err := loadPodQueries(new.PodQueriesFile)
+	if err != nil {
+		logs.Error("Reload pod queries file %s failed, the old queries are kept: %v", new.PodQueriesFile, err)
+		return
+	}
+	q.queries.Store(queries)
+}
+
+// loadPodQueries loads the pod queries from a YAML file like:
+//
+//	# %s is replaced with the matchers of the pod.
+//	- name: cpu_usage
+//	  query: 'sum(rate(container_cpu_usage_seconds_total{%s}[5m]))'
+//	- name: restarts
+//	  query: 'sum(kube_pod_container_status_restarts_total{%s})'
+//	  labels:
+//	    namespace: namespace
+//	    pod_name: pod
+func loadPodQueries(filename string) ([]namedQuery, error) {
+	if filename == "" {
+		return defaultPodQueries, nil
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var queries []namedQuery
+	if err := yaml.UnmarshalStrict(b, &queries); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for i := range queries {
+		if err := queries[i].validate(); err != nil {
+			return nil, err
+		}
+		if names[queries[i].Name] {
+			return nil, fmt.Errorf("pod query %q is duplicated", queries[i].Name)
+		}
+		names[queries[i].Name] = true
+	}
+
+	return queries, nil
+}
@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/YaoZengzeng/practice/prometheus/config"
+	"github.com/YaoZengzeng/practice/prometheus/promql"
+
+	beecontext "github.com/astaxie/beego/context"
+)
+
+func TestLoadPodQueries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		content string
+		err     string
+	}{
+		{"- name: cpu\n  query: 'sum(rate(cpu{%s}[5m]))'\n", ""},
+		{"- name: memory\n  query: 'sum(used{%[1]s}) / sum(total{%[1]s})'\n", ""},
+		{"- name: restarts\n  query: 'sum(restarts{%s})'\n  labels:\n    namespace: namespace\n    pod_name: pod\n", ""},
+		{"- name: memory\n  query: 'sum(used{%s}) / sum(total{%s})'\n", "invalid placeholders"},
+		{"- name: cpu\n  query: 'sum(cpu)'\n", "no placeholder"},
+		{"- query: 'sum(cpu{%s})'\n", "no name"},
+		{"- name: cpu\n  query: 'sum(cpu{%s}'\n", "unclosed left parenthesis"},
+		{"- name: cpu\n  query: 'sum(cpu{%s})'\n- name: cpu\n  query: 'sum(cpu{%s})'\n", "duplicated"},
+		{"- name: cpu\n  query: 'sum(cpu{%s})'\n  labels:\n    pod_name: pod-name\n", "invalid label name"},
+		{"- name: cpu\n  expr: 'sum(cpu{%s})'\n", "not found"},
+	} {
+		filename := filepath.Join(dir, "queries.yaml")
+		if err := ioutil.WriteFile(filename, []byte(c.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := loadPodQueries(filename)
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("loadPodQueries(%q) failed: %v", c.content, err)
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("loadPodQueries(%q) error = %v, want %q", c.content, err, c.err)
+		}
+	}
+
+	for _, q := range append(nodeQueries, defaultPodQueries...) {
+		if err := q.validate(); err != nil {
+			t.Errorf("built-in query is invalid: %v", err)
+		}
+	}
+}
+
+func TestPodQueriesConfigReloaded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "queries.yaml")
+	if err := ioutil.WriteFile(filename, []byte("- name: cpu\n  query: 'sum(cpu{%s})'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := NewPodQueries("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Get()) != len(defaultPodQueries) {
+		t.Errorf("got %d queries, want the %d default ones", len(q.Get()), len(defaultPodQueries))
+	}
+
+	cfg := *config.Get()
+	cfg.PodQueriesFile = filename
+	q.ConfigReloaded(config.Get(), &cfg)
+	if len(q.Get()) != 1 || q.Get()[0].Name != "cpu" {
+		t.Errorf("queries after reload = %v, want the one of the file", q.Get())
+	}
+
+	// The queries are kept once the file becomes invalid.
+	if err := ioutil.WriteFile(filename, []byte("- name: cpu\n  query: 'sum(cpu{%s}) / sum(total{%s})'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	q.ConfigReloaded(config.Get(), &cfg)
+	if len(q.Get()) != 1 || q.Get()[0].Query != "sum(cpu{%s})" {
+		t.Errorf("queries after invalid reload = %v, want the old ones", q.Get())
+	}
+}
+
+func TestDefaultPodQueries(t *testing.T) {
+	want := map[string]string{
+		"cpu_usage":                `sum(rate(container_cpu_usage_seconds_total{container!="", container!="POD", namespace="default", pod="foo"}[5m]))`,
+		"memory_working_set_bytes": `sum(container_memory_working_set_bytes{container!="", container!="POD", namespace="default", pod="foo"})`,
+		"network_receive_bytes":    `sum(rate(container_network_receive_bytes_total{namespace="default", pod="foo"}[5m]))`,
+		"network_transmit_bytes":   `sum(rate(container_network_transmit_bytes_total{namespace="default", pod="foo"}[5m]))`,
+		"restarts":                 `sum(kube_pod_container_status_restarts_total{namespace="default", pod="foo"})`,
+	}
+	if len(defaultPodQueries) != len(want) {
+		t.Fatalf("got %d default queries, want %d", len(defaultPodQueries), len(want))
+	}
+	for _, q := range defaultPodQueries {
+		if err := q.validate(); err != nil {
+			t.Errorf("default query %s is invalid: %v", q.Name, err)
+		}
+		matchPod := promql.MatchersString(podMatchers(config.Get().Labels.Override(q.Labels), "default", "foo"))
+		if got := fmt.Sprintf(q.Query, matchPod); got != want[q.Name] {
+			t.Errorf("default query %s = %s, want %s", q.Name, got, want[q.Name])
+		}
+	}
+}
+
+func TestMonitorPod(t *testing.T) {
+	queries := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries[r.FormValue("query")] = true
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[]}}`))
+	}))
+	defer server.Close()
+
+	defer config.Set(config.Get())
+	cfg := *config.Get()
+	cfg.PrometheusURL = server.URL
+	cfg.Clusters = nil
+	cfg.ClustersFile = ""
+	config.Set(&cfg)
+
+	podQueries, err := NewPodQueries("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &PrometheusController{
+		Clusters:   NewClusterRegistry(),
+		Clients:    NewClientPool(),
+		PodQueries: podQueries,
+	}
+	r := httptest.NewRequest("GET", "/?start=0&end=3600&step=60", nil)
+	w := httptest.NewRecorder()
+	p.Ctx = beecontext.NewContext()
+	p.Ctx.Reset(w, r)
+	p.Ctx.Input.SetParam(":cluster", "ca")
+	p.Ctx.Input.SetParam(":namespace", "default")
+	p.Ctx.Input.SetParam(":pod", "foo")
+
+	p.MonitorPod()
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, query := range []string{
+		// cAdvisor and kube-state-metrics export the pod in their own labels.
+		`sum(container_memory_working_set_bytes{container!="", container!="POD", namespace="default", pod="foo"})`,
+		`sum(kube_pod_container_status_restarts_total{namespace="default", pod="foo"})`,
+	} {
+		if !queries[query] {
+			t.Errorf("query %s is not sent, got %v", query, queries)
+		}
+	}
+}
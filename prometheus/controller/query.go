@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/YaoZengzeng/practice/prometheus/config"
+	"github.com/YaoZengzeng/practice/prometheus/promql"
+
+	"github.com/astaxie/beego/logs"
+)
+
+// namespaceQuery returns the query form value with the namespace matcher enforced on
+// every vector selector, so the query could not read the samples of other namespaces.
+func (p *PrometheusController) namespaceQuery(cluster, namespace string) (string, error) {
+	query := p.Ctx.Request.FormValue("query")
+	if query == "" {
+		return "", fmt.Errorf("Query is empty")
+	}
+
+	enforced, err := promql.EnforceMatchers(query, promql.NewEqualMatcher(p.podLabels(cluster).Namespace, namespace))
+	if err != nil {
+		return "", fmt.Errorf("Parse query failed: %v", err)
+	}
+
+	return enforced, nil
+}
+
+func (p *PrometheusController) QueryNamespace() *queryResult {
+	cluster := p.GetString(":cluster")
+	namespace := p.GetString(":namespace")
+	logs.Info("cluster: %s, namespace: %s", cluster, namespace)
+
+	r := p.Ctx.Request
+
+	query, err := p.namespaceQuery(cluster, namespace)
+	if err != nil {
+		return &queryResult{
+			Status:    statusError,
+			ErrorType: errorBadData,
+			Error:     err.Error(),
+		}
+	}
+
+	ts := time.Now()
+	if t := r.FormValue("time"); t != "" {
+		ts, err = parseTime(t)
+		if err != nil {
+			return &queryResult{
+				Status:    statusError,
+				ErrorType: errorBadData,
+				Error:     fmt.Sprintf("Parse time failed: %v", err),
+			}
+		}
+	}
+
+	client, err := p.getClusterClient(cluster)
+	if err != nil {
+		return &queryResult{
+			Status:    statusError,
+			ErrorType: classifyError(err),
+			Error:     fmt.Sprintf("Get Prometheus client failed: %v", err),
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Get().Query.Timeout)
+	defer cancel()
+
+	value, err := client.Query(ctx, query, ts)
+	if err != nil {
+		return &queryResult{
+			Status:    statusError,
+			ErrorType: classifyError(err),
+			Error:     fmt.Sprintf("Query Prometheus failed: %v", err),
+		}
+	}
+
+	return &queryResult{
+		Status: statusSuccess,
+		Data: &queryData{
+			ResultType: value.Type().String(),
+			Result:     value,
+		},
+	}
+}
+
+func (p *PrometheusController) NamespaceQuery() {
+	p.writeResult(p.QueryNamespace())
+}
+
+func (p *PrometheusController) QueryNamespaceRange() *queryResult {
+	cluster := p.GetString(":cluster")
+	namespace := p.GetString(":namespace")
+	logs.Info("cluster: %s, namespace: %s", cluster, namespace)
+
+	r := p.Ctx.Request
+
+	query, err := p.namespaceQuery(cluster, namespace)
+	if err != nil {
+		return &queryResult{
+			Status:    statusError,
+			ErrorType: errorBadData,
+			Error:     err.Error(),
+		}
+	}
+
+	timeRange, err := parseRange(r)
+	if err != nil {
+		return &queryResult{
+			Status:    statusError,
+			ErrorType: errorBadData,
+			Error:     err.Error(),
+		}
+	}
+
+	client, err := p.getClusterClient(cluster)
+	if err != nil {
+		return &queryResult{
+			Status:    statusError,
+			ErrorType: classifyError(err),
+			Error:     fmt.Sprintf("Get Prometheus client failed: %v", err),
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.Get().Query.Timeout)
+	defer cancel()
+
+	value, err := client.QueryRange(ctx, query, timeRange)
+	if err != nil {
+		return &queryResult{
+			Status:    statusError,
+			ErrorType: classifyError(err),
+			Error:     fmt.Sprintf("Query Prometheus failed: %v", err),
+		}
+	}
+
+	return &queryResult{
+		Status: statusSuccess,
+		Data: &queryData{
+			ResultType: value.Type().String(),
+			Result:     value,
+		},
+	}
+}
+
+func (p *PrometheusController) NamespaceQueryRange() {
+	p.writeResult(p.QueryNamespaceRange())
+}
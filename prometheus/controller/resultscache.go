@@ -0,0 +1,335 @@
+package controller
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/YaoZengzeng/practice/prometheus/config"
+	"github.com/YaoZengzeng/practice/prometheus/metrics"
+
+	"github.com/astaxie/beego/logs"
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// ResultsCache caches the results of range queries in chunks aligned to the step, so
+// a dashboard refreshing the same window only queries the chunks not cached yet,
+// which are usually the tail of the window.
+type ResultsCache struct {
+	sync.Mutex
+	maxEntries int
+	lru        *list.List
+	entries    map[string]*list.Element
+
+	hits   uint64
+	misses uint64
+}
+
+type resultsCacheEntry struct {
+	key    string
+	matrix model.Matrix
+	// expires is zero if the chunk is old enough to never change.
+	expires time.Time
+}
+
+func NewResultsCache(maxEntries int) *ResultsCache {
+	return &ResultsCache{
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// Stats returns the number of chunk hits and misses since the cache was created.
+func (c *ResultsCache) Stats() (hits, misses uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
+
+func (c *ResultsCache) get(key string) (model.Matrix, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		metrics.ResultsCacheRequests.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+
+	entry := elem.Value.(*resultsCacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		atomic.AddUint64(&c.misses, 1)
+		metrics.ResultsCacheRequests.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	atomic.AddUint64(&c.hits, 1)
+	metrics.ResultsCacheRequests.WithLabelValues("hit").Inc()
+
+	return copyMatrix(entry.matrix), true
+}
+
+func (c *ResultsCache) set(key string, matrix model.Matrix, ttl time.Duration) {
+	if c.maxEntries <= 0 {
+		return
+	}
+
+	entry := &resultsCacheEntry{
+		key:    key,
+		matrix: copyMatrix(matrix),
+	}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*resultsCacheEntry).key)
+	}
+}
+
+// copyMatrix deep copies matrix, since the results are modified by the handlers.
+func copyMatrix(matrix model.Matrix) model.Matrix {
+	copied := make(model.Matrix, 0, len(matrix))
+	for _, ss := range matrix {
+		copied = append(copied, &model.SampleStream{
+			Metric: ss.Metric.Clone(),
+			Values: append([]model.SamplePair(nil), ss.Values...),
+		})
+	}
+	return copied
+}
+
+// chunkSize returns the size of the chunks a range query of step is split into.
+func chunkSize(step time.Duration) time.Duration {
+	if step < time.Minute {
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// alignDown returns the last multiple of d not after t.
+func alignDown(t time.Time, d time.Duration) time.Time {
+	return time.Unix(0, t.UnixNano()-t.UnixNano()%int64(d))
+}
+
+// alignUp returns the first multiple of d not before t.
+func alignUp(t time.Time, d time.Duration) time.Time {
+	if aligned := alignDown(t, d); !aligned.Before(t) {
+		return aligned
+	}
+	return alignDown(t, d).Add(d)
+}
+
+// cachingAPI wraps API to serve QueryRange from the results cache.
+type cachingAPI struct {
+	API
+
+	cache *ResultsCache
+	// id identifies the Prometheus of the cached results.
+	id string
+}
+
+// resultsChunk is the part of a range query in a chunk.
+type resultsChunk struct {
+	key string
+	// The first and the last points of the range in the chunk.
+	start, end time.Time
+	// complete is true if all the points of the chunk are in the range, only the
+	// complete chunks are cached.
+	complete bool
+	// fresh is true if the chunk has recent samples which may still change.
+	fresh  bool
+	matrix model.Matrix
+}
+
+func (c *cachingAPI) QueryRange(ctx context.Context, query string, r apiv1.Range) (model.Value, error) {
+	if c.cache.maxEntries <= 0 || r.Step <= 0 {
+		return c.API.QueryRange(ctx, query, r)
+	}
+
+	// Align the range to the step, so the timestamps of the points are always the
+	// multiples of step, no matter when the query is made.
+	start := alignDown(r.Start, r.Step)
+	end := alignDown(r.End, r.Step)
+	size := chunkSize(r.Step)
+	fresh := time.Now().Add(-config.Get().Cache.ResultsMaxFreshness)
+
+	var chunks []*resultsChunk
+	hits := 0
+	for chunkStart := alignDown(start, size); !chunkStart.After(end); chunkStart = chunkStart.Add(size) {
+		chunkEnd := chunkStart.Add(size)
+
+		chunk := &resultsChunk{
+			key:      fmt.Sprintf("%s|%s|%d|%d", c.id, query, r.Step, chunkStart.UnixNano()),
+			start:    alignUp(chunkStart, r.Step),
+			end:      alignDown(chunkEnd.Add(-time.Nanosecond), r.Step),
+			complete: !chunkStart.Before(start) && !chunkEnd.After(end.Add(r.Step)),
+			fresh:    chunkEnd.After(fresh),
+		}
+		if chunk.start.Before(start) {
+			chunk.start = start
+		}
+		if chunk.end.After(end) {
+			chunk.end = end
+		}
+		if chunk.end.Before(chunk.start) {
+			continue
+		}
+
+		if chunk.complete {
+			if matrix, ok := c.cache.get(chunk.key); ok {
+				hits++
+				chunk.matrix = matrix
+			}
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	// The consecutive chunks not cached are queried together, usually the head and
+	// the tail of the range, so a cold cache costs a single query like no cache.
+	queries := 0
+	for i := 0; i < len(chunks); {
+		if chunks[i].matrix != nil {
+			i++
+			continue
+		}
+		j := i
+		for j+1 < len(chunks) && chunks[j+1].matrix == nil {
+			j++
+		}
+
+		value, err := c.API.QueryRange(ctx, query, apiv1.Range{Start: chunks[i].start, End: chunks[j].end, Step: r.Step})
+		if err != nil {
+			return nil, err
+		}
+		matrix, ok := value.(model.Matrix)
+		if !ok {
+			return nil, fmt.Errorf("The type of QueryRange value is unexpected")
+		}
+		queries++
+
+		for _, chunk := range chunks[i : j+1] {
+			chunk.matrix = sliceMatrix(matrix, chunk.start, chunk.end)
+			if chunk.complete {
+				// The recent samples may still change, e.g. the scrapes not ingested yet.
+				var ttl time.Duration
+				if chunk.fresh {
+					ttl = config.Get().Cache.ResultsTTL
+				}
+				c.cache.set(chunk.key, chunk.matrix, ttl)
+			}
+		}
+		i = j + 1
+	}
+
+	logs.Debug("results cache of %q: %d chunks hit, %d chunks missed by %d queries", query, hits, len(chunks)-hits, queries)
+
+	matrices := make([]model.Matrix, 0, len(chunks))
+	for _, chunk := range chunks {
+		matrices = append(matrices, chunk.matrix)
+	}
+	return mergeMatrices(matrices), nil
+}
+
+// sliceMatrix returns the points of matrix between start and end inclusive, the
+// series without such points are dropped.
+func sliceMatrix(matrix model.Matrix, start, end time.Time) model.Matrix {
+	from, to := model.TimeFromUnixNano(start.UnixNano()), model.TimeFromUnixNano(end.UnixNano())
+
+	sliced := model.Matrix{}
+	for _, ss := range matrix {
+		i := sort.Search(len(ss.Values), func(i int) bool {
+			return !ss.Values[i].Timestamp.Before(from)
+		})
+		j := sort.Search(len(ss.Values), func(i int) bool {
+			return ss.Values[i].Timestamp.After(to)
+		})
+		if i >= j {
+			continue
+		}
+		sliced = append(sliced, &model.SampleStream{
+			Metric: ss.Metric,
+			Values: ss.Values[i:j],
+		})
+	}
+	return sliced
+}
+
+// mergeMatrices merges the matrices of consecutive ranges in order.
+func mergeMatrices(matrices []model.Matrix) model.Matrix {
+	if len(matrices) == 1 {
+		return matrices[0]
+	}
+
+	merged := make(map[model.Fingerprint]*model.SampleStream)
+	for _, matrix := range matrices {
+		for _, ss := range matrix {
+			fp := ss.Metric.Fingerprint()
+			if m, ok := merged[fp]; ok {
+				m.Values = append(m.Values, ss.Values...)
+				continue
+			}
+			merged[fp] = &model.SampleStream{
+				Metric: ss.Metric,
+				Values: append([]model.SamplePair(nil), ss.Values...),
+			}
+		}
+	}
+
+	result := make(model.Matrix, 0, len(merged))
+	for _, ss := range merged {
+		result = append(result, ss)
+	}
+	sort.Sort(result)
+
+	return result
+}
+
+// getCachingClient returns the client of the Prometheus which monitors cluster, whose
+// range queries are served from the results cache.
+func (p *PrometheusController) getCachingClient(cluster string) (API, error) {
+	ds, err := p.Clusters.Get(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := p.getClient(ds)
+	if err != nil {
+		return nil, err
+	}
+
+	client = &instrumentedAPI{API: client, cluster: p.Clusters.metricsLabel(cluster)}
+
+	return &cachingAPI{API: client, cache: p.ResultsCache, id: resultsCacheID(ds)}, nil
+}
+
+// resultsCacheID returns the id of the cached results of ds. The clusters sharing a
+// Prometheus may see different series with their own credentials, so the credentials
+// are a part of the id.
+func resultsCacheID(ds *DataSource) string {
+	h := sha256.Sum256([]byte(strings.Join([]string{ds.Token, ds.CertFile, ds.KeyFile}, "\x00")))
+	return ds.Url + "|" + hex.EncodeToString(h[:])
+}
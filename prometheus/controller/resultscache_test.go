@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// rangeAPI returns a point per step of the range, whose value is its timestamp.
+func rangeAPI(ranges *[]apiv1.Range) *fakeAPI {
+	return &fakeAPI{
+		queryRange: func(ctx context.Context, query string, r apiv1.Range) (model.Value, error) {
+			*ranges = append(*ranges, r)
+
+			ss := &model.SampleStream{Metric: model.Metric{model.MetricNameLabel: model.LabelValue(query)}}
+			for t := r.Start; !t.After(r.End); t = t.Add(r.Step) {
+				ss.Values = append(ss.Values, model.SamplePair{
+					Timestamp: model.TimeFromUnixNano(t.UnixNano()),
+					Value:     model.SampleValue(t.Unix()),
+				})
+			}
+			return model.Matrix{ss}, nil
+		},
+	}
+}
+
+func TestResultsCache(t *testing.T) {
+	var ranges []apiv1.Range
+	client := &cachingAPI{API: rangeAPI(&ranges), cache: NewResultsCache(100), id: "test"}
+
+	step := 15 * time.Second
+	start := time.Date(2019, 1, 1, 10, 30, 7, 0, time.UTC)
+	end := start.Add(3 * time.Hour)
+
+	check := func(start, end time.Time) {
+		value, err := client.QueryRange(context.Background(), "up", apiv1.Range{Start: start, End: end, Step: step})
+		if err != nil {
+			t.Fatalf("QueryRange failed: %v", err)
+		}
+		matrix := value.(model.Matrix)
+		if len(matrix) != 1 {
+			t.Fatalf("expected 1 series, got %d", len(matrix))
+		}
+
+		from, to := alignDown(start, step), alignDown(end, step)
+		values := matrix[0].Values
+		if want := int(to.Sub(from)/step) + 1; len(values) != want {
+			t.Fatalf("expected %d points, got %d", want, len(values))
+		}
+		for i, v := range values {
+			if want := from.Add(time.Duration(i) * step); !v.Timestamp.Time().Equal(want) {
+				t.Fatalf("expected point %d at %v, got %v", i, want, v.Timestamp.Time())
+			}
+		}
+	}
+
+	// 10:30 - 13:30 is split into 4 chunks, only 11:00 and 12:00 are complete. The
+	// chunks are not cached, so they are queried together.
+	check(start, end)
+	if len(ranges) != 1 {
+		t.Fatalf("expected 1 query, got %d", len(ranges))
+	}
+	if hits, misses := client.cache.Stats(); hits != 0 || misses != 2 {
+		t.Fatalf("expected 0 hits and 2 misses, got %d and %d", hits, misses)
+	}
+
+	// 11:00 - 14:10, 11:00 and 12:00 are cached, 13:00 is completed now.
+	ranges = nil
+	check(start.Add(30*time.Minute), end.Add(40*time.Minute))
+	if len(ranges) != 1 {
+		t.Fatalf("expected 1 query, got %d", len(ranges))
+	}
+	if !ranges[0].Start.Equal(time.Date(2019, 1, 1, 13, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected to query from 13:00, got %v", ranges[0].Start)
+	}
+	if hits, misses := client.cache.Stats(); hits != 2 || misses != 3 {
+		t.Fatalf("expected 2 hits and 3 misses, got %d and %d", hits, misses)
+	}
+
+	// The cached results should not be modified by the callers.
+	value, _ := client.QueryRange(context.Background(), "up", apiv1.Range{Start: start.Add(30 * time.Minute), End: end, Step: step})
+	value.(model.Matrix)[0].Values[0].Value = -1
+	ranges = nil
+	check(start.Add(30*time.Minute), end)
+	if len(ranges) != 1 {
+		t.Fatalf("expected 1 query, got %d", len(ranges))
+	}
+
+	// 10:00 - 14:50, the chunks of 10:00 and 14:00 are queried apart around the
+	// cached ones.
+	ranges = nil
+	check(start.Add(-30*time.Minute), end.Add(80*time.Minute))
+	if len(ranges) != 2 {
+		t.Fatalf("expected 2 queries, got %d", len(ranges))
+	}
+	if !ranges[0].End.Before(time.Date(2019, 1, 1, 11, 0, 0, 0, time.UTC)) || ranges[1].Start.Before(time.Date(2019, 1, 1, 14, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected to query 10:00 and 14:00, got %v", ranges)
+	}
+}
+
+func TestResultsCacheID(t *testing.T) {
+	a := resultsCacheID(&DataSource{Url: "http://prometheus:9090", Token: "a"})
+	b := resultsCacheID(&DataSource{Url: "http://prometheus:9090", Token: "b"})
+	if a == b {
+		t.Errorf("the clusters with different tokens share the results cache")
+	}
+	if a != resultsCacheID(&DataSource{Url: "http://prometheus:9090", Token: "a"}) {
+		t.Errorf("the results cache id is not stable")
+	}
+}
+
+func TestResultsCacheEviction(t *testing.T) {
+	cache := NewResultsCache(2)
+	cache.set("a", model.Matrix{}, 0)
+	cache.set("b", model.Matrix{}, 0)
+	cache.get("a")
+	cache.set("c", model.Matrix{}, 0)
+
+	if _, ok := cache.get("b"); ok {
+		t.Errorf("expected b to be evicted")
+	}
+	if _, ok := cache.get("a"); !ok {
+		t.Errorf("expected a to be cached")
+	}
+
+	cache.set("d", model.Matrix{}, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, ok := cache.get("d"); ok {
+		t.Errorf("expected d to be expired")
+	}
+}
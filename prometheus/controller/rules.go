@@ -0,0 +1,445 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/YaoZengzeng/practice/prometheus/config"
+	"github.com/YaoZengzeng/practice/prometheus/promql"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	prometheusRuleAPIVersion = "monitoring.coreos.com/v1"
+
+	// The PrometheusRules created by the backend are labelled, so the ones applied
+	// by other means, e.g. promoperator/rules-deadman.yaml, are never touched.
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "prometheus-backend"
+)
+
+var (
+	errKubernetesNotConfigured = errors.New("cluster has no Kubernetes API server configured")
+	errRuleNotManaged          = errors.New("alert rules are not managed by the backend")
+)
+
+// PrometheusRule is the monitoring.coreos.com/v1 PrometheusRule of prometheus-operator.
+type PrometheusRule struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Metadata   ObjectMeta         `json:"metadata"`
+	Spec       PrometheusRuleSpec `json:"spec"`
+}
+
+type ObjectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+}
+
+type PrometheusRuleSpec struct {
+	Groups []RuleGroup `json:"groups"`
+}
+
+type RuleGroup struct {
+	Name     string `json:"name"`
+	Interval string `json:"interval,omitempty"`
+	Rules    []Rule `json:"rules"`
+}
+
+// Rule is either an alerting rule or a recording rule.
+type Rule struct {
+	Alert       string            `json:"alert,omitempty"`
+	Record      string            `json:"record,omitempty"`
+	Expr        string            `json:"expr"`
+	For         string            `json:"for,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// alertRules is what the frontend reads and writes, a PrometheusRule without the
+// details of Kubernetes.
+type alertRules struct {
+	Name            string      `json:"name"`
+	ResourceVersion string      `json:"resourceVersion,omitempty"`
+	Groups          []RuleGroup `json:"groups"`
+}
+
+func newAlertRules(rule *PrometheusRule) *alertRules {
+	return &alertRules{
+		Name:            rule.Metadata.Name,
+		ResourceVersion: rule.Metadata.ResourceVersion,
+		Groups:          rule.Spec.Groups,
+	}
+}
+
+var dns1123Subdomain = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// validate checks the alert rules like Prometheus loading them, so an invalid rule is
+// rejected instead of breaking the rule reloading of Prometheus.
+func (a *alertRules) validate() error {
+	if len(a.Name) > 253 || !dns1123Subdomain.MatchString(a.Name) {
+		return fmt.Errorf("invalid name %q, it should be a DNS subdomain", a.Name)
+	}
+	if len(a.Groups) == 0 {
+		return fmt.Errorf("no rule groups")
+	}
+
+	groups := make(map[string]struct{})
+	for _, group := range a.Groups {
+		if group.Name == "" {
+			return fmt.Errorf("rule group has no name")
+		}
+		if _, ok := groups[group.Name]; ok {
+			return fmt.Errorf("duplicated rule group %q", group.Name)
+		}
+		groups[group.Name] = struct{}{}
+
+		if group.Interval != "" {
+			if _, err := model.ParseDuration(group.Interval); err != nil {
+				return fmt.Errorf("rule group %q: invalid interval: %v", group.Name, err)
+			}
+		}
+		if len(group.Rules) == 0 {
+			return fmt.Errorf("rule group %q has no rules", group.Name)
+		}
+
+		for i, rule := range group.Rules {
+			if err := rule.validate(); err != nil {
+				return fmt.Errorf("rule group %q, rule %d: %v", group.Name, i, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *Rule) validate() error {
+	switch {
+	case r.Alert != "" && r.Record != "":
+		return fmt.Errorf("only one of alert and record should be set")
+
+	case r.Alert == "" && r.Record == "":
+		return fmt.Errorf("one of alert and record should be set")
+
+	case r.Record != "":
+		if !model.IsValidMetricName(model.LabelValue(r.Record)) {
+			return fmt.Errorf("invalid recording rule name %q", r.Record)
+		}
+		if r.For != "" || len(r.Annotations) != 0 {
+			return fmt.Errorf("for and annotations are only allowed in alerting rules")
+		}
+	}
+
+	if err := promql.Validate(r.Expr); err != nil {
+		return fmt.Errorf("invalid expr: %v", err)
+	}
+
+	if r.For != "" {
+		if _, err := model.ParseDuration(r.For); err != nil {
+			return fmt.Errorf("invalid for: %v", err)
+		}
+	}
+
+	for name := range r.Labels {
+		if !promql.IsLabelName(name) {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	for name := range r.Annotations {
+		if !promql.IsLabelName(name) {
+			return fmt.Errorf("invalid annotation name %q", name)
+		}
+	}
+
+	return nil
+}
+
+// enforceMatchers adds matchers to every vector selector of the exprs of the rules.
+func (a *alertRules) enforceMatchers(matchers ...*promql.Matcher) error {
+	for _, group := range a.Groups {
+		for i := range group.Rules {
+			expr, err := promql.EnforceMatchers(group.Rules[i].Expr, matchers...)
+			if err != nil {
+				return fmt.Errorf("rule group %q, rule %d: invalid expr: %v", group.Name, i, err)
+			}
+			group.Rules[i].Expr = expr
+		}
+	}
+	return nil
+}
+
+// ruleLabels returns the labels of the PrometheusRules created by the backend.
+func ruleLabels() map[string]string {
+	labels := map[string]string{
+		managedByLabel: managedByValue,
+	}
+	for _, label := range strings.Split(config.Get().RuleLabels, ",") {
+		if kv := strings.SplitN(label, "=", 2); len(kv) == 2 {
+			labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return labels
+}
+
+func (p *PrometheusController) getRuleClient(cluster string) (RuleClient, error) {
+	ds, err := p.Clusters.Get(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.RuleClients.Get(ds.Kubernetes)
+}
+
+// getManagedRule returns the PrometheusRule if it's created by the backend.
+func getManagedRule(ctx context.Context, client RuleClient, namespace, name string) (*PrometheusRule, error) {
+	rule, err := client.Get(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if rule.Metadata.Labels[managedByLabel] != managedByValue {
+		return nil, errRuleNotManaged
+	}
+	return rule, nil
+}
+
+// decodeAlertRules reads the alert rules from the request body and validates them.
+func (p *PrometheusController) decodeAlertRules() (*alertRules, *queryResult) {
+	rules := &alertRules{}
+	if err := json.NewDecoder(p.Ctx.Request.Body).Decode(rules); err != nil {
+		return nil, &queryResult{
+			Status:    statusError,
+			ErrorType: errorBadData,
+			Error:     fmt.Sprintf("Unmarshal alert rules failed: %v", err),
+		}
+	}
+
+	if name := p.GetString(":rule"); name != "" {
+		if rules.Name != "" && rules.Name != name {
+			return nil, &queryResult{
+				Status:    statusError,
+				ErrorType: errorBadData,
+				Error:     fmt.Sprintf("Name %q of alert rules mismatches the path", rules.Name),
+			}
+		}
+		rules.Name = name
+	}
+
+	if err := rules.validate(); err != nil {
+		return nil, &queryResult{
+			Status:    statusError,
+			ErrorType: errorBadData,
+			Error:     fmt.Sprintf("Invalid alert rules: %v", err),
+		}
+	}
+
+	// Like the namespace queries, the rules could only select the samples of their
+	// namespace.
+	namespace := promql.NewEqualMatcher(p.podLabels(p.GetString(":cluster")).Namespace, p.GetString(":namespace"))
+	if err := rules.enforceMatchers(namespace); err != nil {
+		return nil, &queryResult{
+			Status:    statusError,
+			ErrorType: errorBadData,
+			Error:     fmt.Sprintf("Invalid alert rules: %v", err),
+		}
+	}
+
+	return rules, nil
+}
+
+func ruleError(operation string, err error) *queryResult {
+	return &queryResult{
+		Status:    statusError,
+		ErrorType: classifyError(err),
+		Error:     fmt.Sprintf("%s alert rules failed: %v", operation, err),
+	}
+}
+
+func (p *PrometheusController) QueryListRules() *queryResult {
+	cluster := p.GetString(":cluster")
+	namespace := p.GetString(":namespace")
+	logs.Info("cluster: %s, namespace: %s", cluster, namespace)
+
+	client, err := p.getRuleClient(cluster)
+	if err != nil {
+		return ruleError("List", err)
+	}
+
+	ctx, cancel := context.WithTimeout(p.Ctx.Request.Context(), config.Get().Query.Timeout)
+	defer cancel()
+
+	rules, err := client.List(ctx, namespace, map[string]string{managedByLabel: managedByValue})
+	if err != nil {
+		return ruleError("List", err)
+	}
+
+	data := []*alertRules{}
+	for _, rule := range rules {
+		data = append(data, newAlertRules(rule))
+	}
+
+	return &queryResult{
+		Status: statusSuccess,
+		Data:   data,
+	}
+}
+
+func (p *PrometheusController) ListRules() {
+	p.writeResult(p.QueryListRules())
+}
+
+func (p *PrometheusController) QueryGetRule() *queryResult {
+	cluster := p.GetString(":cluster")
+	namespace := p.GetString(":namespace")
+	name := p.GetString(":rule")
+	logs.Info("cluster: %s, namespace: %s, rule: %s", cluster, namespace, name)
+
+	client, err := p.getRuleClient(cluster)
+	if err != nil {
+		return ruleError("Get", err)
+	}
+
+	ctx, cancel := context.WithTimeout(p.Ctx.Request.Context(), config.Get().Query.Timeout)
+	defer cancel()
+
+	rule, err := getManagedRule(ctx, client, namespace, name)
+	if err != nil {
+		return ruleError("Get", err)
+	}
+
+	return &queryResult{
+		Status: statusSuccess,
+		Data:   newAlertRules(rule),
+	}
+}
+
+func (p *PrometheusController) GetRule() {
+	p.writeResult(p.QueryGetRule())
+}
+
+func (p *PrometheusController) QueryCreateRule() *queryResult {
+	cluster := p.GetString(":cluster")
+	namespace := p.GetString(":namespace")
+	logs.Info("cluster: %s, namespace: %s", cluster, namespace)
+
+	rules, result := p.decodeAlertRules()
+	if result != nil {
+		return result
+	}
+
+	client, err := p.getRuleClient(cluster)
+	if err != nil {
+		return ruleError("Create", err)
+	}
+
+	ctx, cancel := context.WithTimeout(p.Ctx.Request.Context(), config.Get().Query.Timeout)
+	defer cancel()
+
+	rule, err := client.Create(ctx, &PrometheusRule{
+		APIVersion: prometheusRuleAPIVersion,
+		Kind:       "PrometheusRule",
+		Metadata: ObjectMeta{
+			Name:      rules.Name,
+			Namespace: namespace,
+			Labels:    ruleLabels(),
+		},
+		Spec: PrometheusRuleSpec{Groups: rules.Groups},
+	})
+	if err != nil {
+		return ruleError("Create", err)
+	}
+
+	return &queryResult{
+		Status: statusSuccess,
+		Data:   newAlertRules(rule),
+	}
+}
+
+func (p *PrometheusController) CreateRule() {
+	p.writeResult(p.QueryCreateRule())
+}
+
+func (p *PrometheusController) QueryUpdateRule() *queryResult {
+	cluster := p.GetString(":cluster")
+	namespace := p.GetString(":namespace")
+	name := p.GetString(":rule")
+	logs.Info("cluster: %s, namespace: %s, rule: %s", cluster, namespace, name)
+
+	rules, result := p.decodeAlertRules()
+	if result != nil {
+		return result
+	}
+
+	client, err := p.getRuleClient(cluster)
+	if err != nil {
+		return ruleError("Update", err)
+	}
+
+	ctx, cancel := context.WithTimeout(p.Ctx.Request.Context(), config.Get().Query.Timeout)
+	defer cancel()
+
+	rule, err := getManagedRule(ctx, client, namespace, name)
+	if err != nil {
+		return ruleError("Update", err)
+	}
+
+	// Without resourceVersion the last update wins, otherwise the update fails with
+	// a conflict if the rules were modified since the frontend read them.
+	if rules.ResourceVersion != "" {
+		rule.Metadata.ResourceVersion = rules.ResourceVersion
+	}
+	rule.Spec.Groups = rules.Groups
+
+	if rule, err = client.Update(ctx, rule); err != nil {
+		return ruleError("Update", err)
+	}
+
+	return &queryResult{
+		Status: statusSuccess,
+		Data:   newAlertRules(rule),
+	}
+}
+
+func (p *PrometheusController) UpdateRule() {
+	p.writeResult(p.QueryUpdateRule())
+}
+
+func (p *PrometheusController) QueryDeleteRule() *queryResult {
+	cluster := p.GetString(":cluster")
+	namespace := p.GetString(":namespace")
+	name := p.GetString(":rule")
+	logs.Info("cluster: %s, namespace: %s, rule: %s", cluster, namespace, name)
+
+	client, err := p.getRuleClient(cluster)
+	if err != nil {
+		return ruleError("Delete", err)
+	}
+
+	ctx, cancel := context.WithTimeout(p.Ctx.Request.Context(), config.Get().Query.Timeout)
+	defer cancel()
+
+	rule, err := getManagedRule(ctx, client, namespace, name)
+	if err != nil {
+		return ruleError("Delete", err)
+	}
+
+	// Make sure the rules checked above are the ones deleted.
+	if err := client.Delete(ctx, namespace, name, rule.Metadata.ResourceVersion); err != nil {
+		return ruleError("Delete", err)
+	}
+
+	return &queryResult{
+		Status: statusSuccess,
+	}
+}
+
+func (p *PrometheusController) DeleteRule() {
+	p.writeResult(p.QueryDeleteRule())
+}
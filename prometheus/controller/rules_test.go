@@ -0,0 +1,306 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	beecontext "github.com/astaxie/beego/context"
+)
+
+// fakeRuleClient implements RuleClient in memory like the API server.
+type fakeRuleClient struct {
+	sync.Mutex
+	version int
+	rules   map[string]*PrometheusRule
+}
+
+func newFakeRuleClient() *fakeRuleClient {
+	return &fakeRuleClient{rules: make(map[string]*PrometheusRule)}
+}
+
+func (f *fakeRuleClient) copy(rule *PrometheusRule) *PrometheusRule {
+	b, _ := json.Marshal(rule)
+	copied := &PrometheusRule{}
+	json.Unmarshal(b, copied)
+	return copied
+}
+
+func (f *fakeRuleClient) List(ctx context.Context, namespace string, labels map[string]string) ([]*PrometheusRule, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	var rules []*PrometheusRule
+next:
+	for _, rule := range f.rules {
+		if rule.Metadata.Namespace != namespace {
+			continue
+		}
+		for name, value := range labels {
+			if rule.Metadata.Labels[name] != value {
+				continue next
+			}
+		}
+		rules = append(rules, f.copy(rule))
+	}
+	return rules, nil
+}
+
+func (f *fakeRuleClient) Get(ctx context.Context, namespace, name string) (*PrometheusRule, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	rule, ok := f.rules[namespace+"/"+name]
+	if !ok {
+		return nil, &kubeError{Code: http.StatusNotFound, Reason: "NotFound"}
+	}
+	return f.copy(rule), nil
+}
+
+func (f *fakeRuleClient) Create(ctx context.Context, rule *PrometheusRule) (*PrometheusRule, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	key := rule.Metadata.Namespace + "/" + rule.Metadata.Name
+	if _, ok := f.rules[key]; ok {
+		return nil, &kubeError{Code: http.StatusConflict, Reason: "AlreadyExists"}
+	}
+	f.version++
+	rule = f.copy(rule)
+	rule.Metadata.ResourceVersion = strconv.Itoa(f.version)
+	f.rules[key] = rule
+	return f.copy(rule), nil
+}
+
+func (f *fakeRuleClient) Update(ctx context.Context, rule *PrometheusRule) (*PrometheusRule, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	key := rule.Metadata.Namespace + "/" + rule.Metadata.Name
+	old, ok := f.rules[key]
+	if !ok {
+		return nil, &kubeError{Code: http.StatusNotFound, Reason: "NotFound"}
+	}
+	if old.Metadata.ResourceVersion != rule.Metadata.ResourceVersion {
+		return nil, &kubeError{Code: http.StatusConflict, Reason: "Conflict"}
+	}
+	f.version++
+	rule = f.copy(rule)
+	rule.Metadata.ResourceVersion = strconv.Itoa(f.version)
+	f.rules[key] = rule
+	return f.copy(rule), nil
+}
+
+func (f *fakeRuleClient) Delete(ctx context.Context, namespace, name, resourceVersion string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	key := namespace + "/" + name
+	old, ok := f.rules[key]
+	if !ok {
+		return &kubeError{Code: http.StatusNotFound, Reason: "NotFound"}
+	}
+	if resourceVersion != "" && old.Metadata.ResourceVersion != resourceVersion {
+		return &kubeError{Code: http.StatusConflict, Reason: "Conflict"}
+	}
+	delete(f.rules, key)
+	return nil
+}
+
+// ruleRequest runs the handler of the rules API with the fake client.
+func ruleRequest(client RuleClient, method, rule, body string) *httptest.ResponseRecorder {
+	pool := NewRuleClientPool(NewKubeClientPool())
+	pool.clients[KubernetesSource{}] = client
+
+	r := httptest.NewRequest(method, "/", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	p := &PrometheusController{
+		Clusters:    NewClusterRegistry(),
+		RuleClients: pool,
+	}
+	p.Ctx = beecontext.NewContext()
+	p.Ctx.Reset(w, r)
+	p.Ctx.Input.SetParam(":cluster", "ca")
+	p.Ctx.Input.SetParam(":namespace", "default")
+	p.Ctx.Input.SetParam(":rule", rule)
+
+	switch {
+	case method == "GET" && rule == "":
+		p.ListRules()
+	case method == "GET":
+		p.GetRule()
+	case method == "POST":
+		p.CreateRule()
+	case method == "PUT":
+		p.UpdateRule()
+	case method == "DELETE":
+		p.DeleteRule()
+	}
+
+	return w
+}
+
+const testRules = `{
+	"name": "pod-down",
+	"groups": [{
+		"name": "pods",
+		"rules": [{
+			"alert": "PodDown",
+			"expr": "up{job=\"pods\"} == 0",
+			"for": "5m",
+			"annotations": {"summary": "Pod is down"}
+		}]
+	}]
+}`
+
+func TestRules(t *testing.T) {
+	client := newFakeRuleClient()
+
+	// The rules applied by others should not be touched.
+	client.Create(context.Background(), &PrometheusRule{
+		Metadata: ObjectMeta{Name: "deadman", Namespace: "default", Labels: map[string]string{"role": "alert-rules"}},
+	})
+
+	if w := ruleRequest(client, "POST", "", testRules); w.Code != http.StatusOK {
+		t.Fatalf("create rules failed: %d %s", w.Code, w.Body)
+	}
+	if w := ruleRequest(client, "POST", "", testRules); w.Code != http.StatusConflict {
+		t.Errorf("expected conflict creating existing rules, got %d %s", w.Code, w.Body)
+	}
+
+	created, _ := client.Get(context.Background(), "default", "pod-down")
+	if created.Metadata.Labels["role"] != "alert-rules" || created.Metadata.Labels[managedByLabel] != managedByValue {
+		t.Errorf("unexpected labels of created rules: %v", created.Metadata.Labels)
+	}
+	want := `up{kubernetes_namespace="default", job="pods"} == 0`
+	if expr := created.Spec.Groups[0].Rules[0].Expr; expr != want {
+		t.Errorf("expr of created rules = %s, want %s", expr, want)
+	}
+
+	w := ruleRequest(client, "GET", "", "")
+	var list struct {
+		Data []*alertRules `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Data) != 1 || list.Data[0].Name != "pod-down" {
+		t.Errorf("expected only the managed rules listed, got %s", w.Body)
+	}
+
+	// Update with a stale resourceVersion should conflict.
+	stale := strings.Replace(testRules, `"name": "pod-down",`, `"resourceVersion": "0",`, 1)
+	if w := ruleRequest(client, "PUT", "pod-down", stale); w.Code != http.StatusConflict {
+		t.Errorf("expected conflict updating with stale resourceVersion, got %d %s", w.Code, w.Body)
+	}
+	updated := strings.Replace(testRules, "5m", "10m", 1)
+	if w := ruleRequest(client, "PUT", "pod-down", updated); w.Code != http.StatusOK {
+		t.Errorf("update rules failed: %d %s", w.Code, w.Body)
+	}
+	if rule, _ := client.Get(context.Background(), "default", "pod-down"); rule.Spec.Groups[0].Rules[0].For != "10m" {
+		t.Errorf("rules not updated: %+v", rule.Spec)
+	}
+
+	// The rules read back keep the enforced matcher only once.
+	w = ruleRequest(client, "GET", "pod-down", "")
+	var got struct {
+		Data *alertRules `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &got)
+	readBack, _ := json.Marshal(got.Data)
+	if w := ruleRequest(client, "PUT", "pod-down", string(readBack)); w.Code != http.StatusOK {
+		t.Errorf("update rules read back failed: %d %s", w.Code, w.Body)
+	}
+	if rule, _ := client.Get(context.Background(), "default", "pod-down"); rule.Spec.Groups[0].Rules[0].Expr != want {
+		t.Errorf("expr of rules updated with those read back = %s, want %s", rule.Spec.Groups[0].Rules[0].Expr, want)
+	}
+
+	if w := ruleRequest(client, "GET", "deadman", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected not found getting unmanaged rules, got %d %s", w.Code, w.Body)
+	}
+	if w := ruleRequest(client, "DELETE", "deadman", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected not found deleting unmanaged rules, got %d %s", w.Code, w.Body)
+	}
+	if w := ruleRequest(client, "DELETE", "pod-down", ""); w.Code != http.StatusOK {
+		t.Errorf("delete rules failed: %d %s", w.Code, w.Body)
+	}
+	if _, err := client.Get(context.Background(), "default", "pod-down"); err == nil {
+		t.Errorf("rules not deleted")
+	}
+}
+
+func TestValidateAlertRules(t *testing.T) {
+	tc := []struct {
+		desc  string
+		rules string
+	}{
+		{"invalid name", strings.Replace(testRules, "pod-down", "Pod_Down", 1)},
+		{"invalid expr", strings.Replace(testRules, `"pods\"}`, `"pods\"`, 1)},
+		{"wrong argument type", strings.Replace(testRules, `up{job=\"pods\"} == 0`, `rate(up{job=\"pods\"}) == 0`, 1)},
+		{"dangling operator", strings.Replace(testRules, `== 0`, `+`, 1)},
+		{"invalid for", strings.Replace(testRules, "5m", "5 minutes", 1)},
+		{"both alert and record", strings.Replace(testRules, `"alert": "PodDown",`, `"alert": "PodDown", "record": "pod:up",`, 1)},
+		{"for of recording rule", strings.Replace(testRules, `"alert": "PodDown",`, `"record": "pod:up",`, 1)},
+		{"no groups", `{"name": "pod-down", "groups": []}`},
+	}
+
+	for _, c := range tc {
+		if w := ruleRequest(newFakeRuleClient(), "POST", "", c.rules); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected bad request, got %d %s", c.desc, w.Code, w.Body)
+		}
+	}
+}
+
+func TestKubeRuleClient(t *testing.T) {
+	var method, path, query, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path, query = r.Method, r.URL.Path, r.URL.RawQuery
+		b := new(strings.Builder)
+		if r.Body != nil {
+			buf := make([]byte, 1024)
+			n, _ := r.Body.Read(buf)
+			b.Write(buf[:n])
+		}
+		body = b.String()
+
+		if r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/missing") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"kind": "Status", "code": 404, "reason": "NotFound", "message": "not found"}`))
+			return
+		}
+		w.Write([]byte(`{"items": []}`))
+	}))
+	defer server.Close()
+
+	kube, err := NewKubeClient(&DataSource{Url: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &kubeRuleClient{kube}
+
+	ctx := context.Background()
+	if _, err := client.List(ctx, "default", map[string]string{"role": "alert-rules"}); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/apis/monitoring.coreos.com/v1/namespaces/default/prometheusrules" || query != "labelSelector=role%3Dalert-rules" {
+		t.Errorf("unexpected list request %s?%s", path, query)
+	}
+
+	_, err = client.Get(ctx, "default", "missing")
+	if kerr, ok := err.(*kubeError); !ok || kerr.Code != http.StatusNotFound {
+		t.Errorf("expected not found error, got %v", err)
+	}
+	if typ := classifyError(err); typ != errorNotFound {
+		t.Errorf("expected error type %s, got %s", errorNotFound, typ)
+	}
+
+	if err := client.Delete(ctx, "default", "pod-down", "42"); err != nil {
+		t.Fatal(err)
+	}
+	if method != "DELETE" || !strings.Contains(body, `"resourceVersion":"42"`) {
+		t.Errorf("unexpected delete request %s %s", method, body)
+	}
+}
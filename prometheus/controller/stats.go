@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/prometheus/common/model"
+)
+
+// seriesStats summarizes the values of a series in the window. The values are
+// model.SampleValue to be marshaled as strings like Prometheus, as they may be NaN.
+type seriesStats struct {
+	Min  model.SampleValue `json:"min"`
+	Max  model.SampleValue `json:"max"`
+	Mean model.SampleValue `json:"mean"`
+	Last model.SampleValue `json:"last"`
+	P50  model.SampleValue `json:"p50"`
+	P95  model.SampleValue `json:"p95"`
+	P99  model.SampleValue `json:"p99"`
+
+	// Increase is set for the counters, the counter resets are taken into account.
+	Increase *model.SampleValue `json:"increase,omitempty"`
+	// Derivative is set for the others, it's the per-second slope estimated by the
+	// linear regression like the deriv() of PromQL.
+	Derivative *model.SampleValue `json:"derivative,omitempty"`
+}
+
+// quantile returns the q-quantile of the sorted values with linear interpolation.
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+
+	rank := q * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	weight := rank - float64(lower)
+
+	return sorted[lower]*(1-weight) + sorted[upper]*weight
+}
+
+// counterIncrease returns the increase of the counter, a decrease of the value is
+// considered as a counter reset.
+func counterIncrease(values []model.SamplePair) float64 {
+	increase := 0.0
+	for i := 1; i < len(values); i++ {
+		prev, cur := float64(values[i-1].Value), float64(values[i].Value)
+		if cur < prev {
+			increase += cur
+		} else {
+			increase += cur - prev
+		}
+	}
+	return increase
+}
+
+// derivative returns the per-second slope of the values by simple linear regression.
+func derivative(values []model.SamplePair) float64 {
+	if len(values) < 2 {
+		return math.NaN()
+	}
+
+	// Use the time relative to the first sample to keep the precision.
+	first := values[0].Timestamp
+	var n, sumX, sumY, sumXY, sumX2 float64
+	for _, v := range values {
+		x := float64(v.Timestamp-first) / 1000
+		y := float64(v.Value)
+		n++
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumX2 += x * x
+	}
+
+	return (n*sumXY - sumX*sumY) / (n*sumX2 - sumX*sumX)
+}
+
+func newSeriesStats(values []model.SamplePair, counter bool) *seriesStats {
+	var sorted []float64
+	sum := 0.0
+	for _, v := range values {
+		if f := float64(v.Value); !math.IsNaN(f) {
+			sorted = append(sorted, f)
+			sum += f
+		}
+	}
+	sort.Float64s(sorted)
+
+	nan := model.SampleValue(math.NaN())
+	stats := &seriesStats{
+		Min:  nan,
+		Max:  nan,
+		Mean: nan,
+		Last: nan,
+	}
+	if len(sorted) != 0 {
+		stats.Min = model.SampleValue(sorted[0])
+		stats.Max = model.SampleValue(sorted[len(sorted)-1])
+		stats.Mean = model.SampleValue(sum / float64(len(sorted)))
+	}
+	if len(values) != 0 {
+		stats.Last = values[len(values)-1].Value
+	}
+	stats.P50 = model.SampleValue(quantile(sorted, 0.5))
+	stats.P95 = model.SampleValue(quantile(sorted, 0.95))
+	stats.P99 = model.SampleValue(quantile(sorted, 0.99))
+
+	if counter {
+		increase := model.SampleValue(counterIncrease(values))
+		stats.Increase = &increase
+	} else {
+		deriv := model.SampleValue(derivative(values))
+		stats.Derivative = &deriv
+	}
+
+	return stats
+}
+
+// metricTypes returns the types of the metrics of the targets selected by match, the
+// series of summaries and histograms are typed as counters as they are cumulative.
+func metricTypes(ctx context.Context, client API, match string) map[string]string {
+	types := make(map[string]string)
+
+	metadata, err := client.TargetsMetadata(ctx, match)
+	if err != nil {
+		// The stats are still useful without the types.
+		logs.Warn("Get targets metadata of %s failed: %v", match, err)
+		return types
+	}
+
+	for _, m := range metadata {
+		switch m.Type {
+		case "summary", "histogram":
+			for _, suffix := range metricSuffixes {
+				types[m.Metric+suffix] = "counter"
+			}
+		}
+		if _, ok := types[m.Metric]; !ok {
+			types[m.Metric] = m.Type
+		}
+	}
+
+	return types
+}
+
+// isCounter returns true if the metric is a counter, the metrics without metadata are
+// guessed by the naming convention.
+func isCounter(types map[string]string, metric string) bool {
+	if typ, ok := types[metric]; ok {
+		return typ == "counter"
+	}
+	return strings.HasSuffix(metric, "_total")
+}
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+func samples(values ...float64) []model.SamplePair {
+	var pairs []model.SamplePair
+	for i, v := range values {
+		pairs = append(pairs, model.SamplePair{Timestamp: model.Time(i * 15000), Value: model.SampleValue(v)})
+	}
+	return pairs
+}
+
+func TestSeriesStats(t *testing.T) {
+	stats := newSeriesStats(samples(4, 1, math.NaN(), 3, 2), false)
+	if stats.Min != 1 || stats.Max != 4 || stats.Mean != 2.5 || stats.Last != 2 {
+		t.Errorf("unexpected min/max/mean/last: %v %v %v %v", stats.Min, stats.Max, stats.Mean, stats.Last)
+	}
+	if stats.P50 != 2.5 || math.Abs(float64(stats.P99)-3.97) > 1e-9 {
+		t.Errorf("unexpected percentiles: p50 %v, p99 %v", stats.P50, stats.P99)
+	}
+	if stats.Increase != nil || stats.Derivative == nil {
+		t.Errorf("expected only derivative for gauges")
+	}
+
+	// The value increases 1 per 15s.
+	stats = newSeriesStats(samples(0, 1, 2, 3), false)
+	if math.Abs(float64(*stats.Derivative)-1.0/15) > 1e-9 {
+		t.Errorf("expected derivative %v, got %v", 1.0/15, *stats.Derivative)
+	}
+
+	// The counter resets after 7.
+	stats = newSeriesStats(samples(5, 7, 2, 4), true)
+	if stats.Derivative != nil || stats.Increase == nil || *stats.Increase != 6 {
+		t.Errorf("expected increase 6 with the counter reset, got %v", stats.Increase)
+	}
+
+	stats = newSeriesStats(nil, false)
+	if !math.IsNaN(float64(stats.Mean)) || !math.IsNaN(float64(stats.P50)) || !math.IsNaN(float64(*stats.Derivative)) {
+		t.Errorf("expected NaN stats of empty series, got %+v", stats)
+	}
+}
+
+type metadataAPI struct {
+	API
+	metadata []MetricMetadata
+}
+
+func (m *metadataAPI) TargetsMetadata(ctx context.Context, matchTarget string) ([]MetricMetadata, error) {
+	return m.metadata, nil
+}
+
+func TestIsCounter(t *testing.T) {
+	types := metricTypes(context.Background(), &metadataAPI{metadata: []MetricMetadata{
+		{Metric: "http_requests", Type: "counter"},
+		{Metric: "errors_total", Type: "gauge"},
+		{Metric: "request_duration_seconds", Type: "histogram"},
+	}}, "")
+
+	tc := map[string]bool{
+		"http_requests":                  true,
+		"errors_total":                   false,
+		"request_duration_seconds_count": true,
+		"request_duration_seconds":       false,
+		"unknown_total":                  true,
+		"unknown":                        false,
+	}
+	for metric, expected := range tc {
+		if isCounter(types, metric) != expected {
+			t.Errorf("isCounter(%s) should be %v", metric, expected)
+		}
+	}
+}
@@ -2,6 +2,10 @@ package controller
 
 import (
 	"fmt"
+	"sort"
+	"sync"
+
+	_ "github.com/go-sql-driver/mysql"
 )
 
 type Store interface {
@@ -14,8 +18,30 @@ type Store interface {
 	ResetPodMetricsRecords(id string, metrics []string) error
 }
 
+// NewStore creates the Store of kind, dsn is the data source name of the database
+// and ignored by the memory store.
+func NewStore(kind, dsn string) (Store, error) {
+	var store Store
+	switch kind {
+	case "memory":
+		store = NewMemoryStore()
+
+	case "mysql":
+		var err error
+		if store, err = NewSQLStore(kind, dsn); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
+	}
+
+	return &instrumentedStore{store}, nil
+}
+
 // memoryStore implements the Store interface, mainly used for testing.
 type memoryStore struct {
+	sync.RWMutex
 	podMetricsRecords map[string]map[string]struct{}
 }
 
@@ -26,6 +52,9 @@ func NewMemoryStore() Store {
 }
 
 func (m *memoryStore) GetPodMetricsRecords(id string) ([]string, error) {
+	m.RLock()
+	defer m.RUnlock()
+
 	if m.podMetricsRecords[id] == nil {
 		return nil, nil
 	}
@@ -34,11 +63,15 @@ func (m *memoryStore) GetPodMetricsRecords(id string) ([]string, error) {
 	for metric, _ := range m.podMetricsRecords[id] {
 		metrics = append(metrics, metric)
 	}
+	sort.Strings(metrics)
 
 	return metrics, nil
 }
 
 func (m *memoryStore) AddPodMetricsRecords(id string, metrics []string) error {
+	m.Lock()
+	defer m.Unlock()
+
 	if m.podMetricsRecords[id] == nil {
 		m.podMetricsRecords[id] = make(map[string]struct{})
 	}
@@ -47,15 +80,38 @@ func (m *memoryStore) AddPodMetricsRecords(id string, metrics []string) error {
 		m.podMetricsRecords[id][metric] = struct{}{}
 	}
 
-	fmt.Printf("%s\n%s\n", id, m.podMetricsRecords[id])
-
 	return nil
 }
 
 func (m *memoryStore) DeletePodMetricsRecords(id string, metrics []string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, metric := range metrics {
+		delete(m.podMetricsRecords[id], metric)
+	}
+
+	if len(m.podMetricsRecords[id]) == 0 {
+		delete(m.podMetricsRecords, id)
+	}
+
 	return nil
 }
 
 func (m *memoryStore) ResetPodMetricsRecords(id string, metrics []string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if len(metrics) == 0 {
+		delete(m.podMetricsRecords, id)
+		return nil
+	}
+
+	records := make(map[string]struct{}, len(metrics))
+	for _, metric := range metrics {
+		records[metric] = struct{}{}
+	}
+	m.podMetricsRecords[id] = records
+
 	return nil
 }
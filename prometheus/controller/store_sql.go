@@ -0,0 +1,327 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/YaoZengzeng/practice/prometheus/config"
+	"github.com/YaoZengzeng/practice/prometheus/promql"
+
+	"github.com/astaxie/beego/logs"
+)
+
+// migration is either a DDL statement or a function migrating the data.
+type migration struct {
+	stmt string
+	data func(tx *sql.Tx) error
+}
+
+// migrations are applied in order, the version of a migration is its index plus one.
+// Never modify an applied migration, append a new one instead. MySQL commits DDL
+// statements implicitly, so they could not be rolled back with the version and must
+// be idempotent, e.g. "IF NOT EXISTS", in case the version fails to be recorded. The
+// data is migrated in a transaction with the version.
+var migrations = []migration{
+	// The metric is hashed because it could be longer than the limit of index key.
+	{stmt: `CREATE TABLE IF NOT EXISTS pod_metrics_records (
+		id          VARCHAR(255)  NOT NULL,
+		metric_hash CHAR(64)      NOT NULL,
+		metric      TEXT          NOT NULL,
+		PRIMARY KEY (id, metric_hash)
+	)`},
+	{data: normalizeRecords},
+}
+
+const (
+	// migrationLock is the name of the lock held while migrating, so the replicas
+	// started together don't run the same migrations.
+	migrationLock = "prometheus_backend_migration"
+	// migrationLockTimeout is the seconds to wait for the lock.
+	migrationLockTimeout = 60
+)
+
+// sqlStore implements the Store interface with a SQL database, so the records survive
+// restarts of the backend.
+type sqlStore struct {
+	db *sql.DB
+}
+
+// NewSQLStore connects to the database specified by driver and dsn and migrates its
+// schema to the latest version.
+func NewSQLStore(driver, dsn string) (Store, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect to database failed: %v", err)
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate database failed: %v", err)
+	}
+
+	return &sqlStore{
+		db: db,
+	}, nil
+}
+
+// migrate applies the migrations which are not applied yet. The lock of MySQL is held
+// by the connection, so all the migrations run in a single one.
+func migrate(db *sql.DB) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, migrationLock, migrationLockTimeout).Scan(&locked); err != nil {
+		return fmt.Errorf("get lock %s failed: %v", migrationLock, err)
+	}
+	if locked.Int64 != 1 {
+		return fmt.Errorf("get lock %s timed out", migrationLock)
+	}
+	defer func() {
+		if err := conn.QueryRowContext(ctx, `SELECT RELEASE_LOCK(?)`, migrationLock).Scan(&locked); err != nil {
+			logs.Error("Release lock %s failed: %v", migrationLock, err)
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+
+	// The version is read after the lock is held, so the migrations applied by
+	// another replica are skipped.
+	var version int
+	if err := conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+
+	for v := version + 1; v <= len(migrations); v++ {
+		if err := applyMigration(ctx, conn, v); err != nil {
+			return fmt.Errorf("migration %d: %v", v, err)
+		}
+		logs.Info("database migrated to version %d", v)
+	}
+
+	return nil
+}
+
+// applyMigration applies the migration of version v and records the version.
+func applyMigration(ctx context.Context, conn *sql.Conn, v int) error {
+	m := migrations[v-1]
+	if m.data == nil {
+		if _, err := conn.ExecContext(ctx, m.stmt); err != nil {
+			return err
+		}
+		_, err := conn.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES (?)`, v)
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := m.data(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, v); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// normalizeRecords rewrites the records saved as the selectors with the pod matchers
+// appended, like `up{job="x", kubernetes_namespace="ns", kubernetes_pod_name="pod"}`,
+// to their normalized form. The records which are not valid selectors are left as they
+// are, they are rejected when queried and could be reset by the callers.
+func normalizeRecords(tx *sql.Tx) error {
+	cfg := config.Get()
+	clusters, err := legacyClusters(cfg)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT id, metric FROM pod_metrics_records`)
+	if err != nil {
+		return err
+	}
+
+	var ids, metrics []string
+	for rows.Next() {
+		var id, metric string
+		if err := rows.Scan(&id, &metric); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+		metrics = append(metrics, metric)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		record, err := normalizeLegacyRecord(id, metrics[i], legacyLabels(cfg, clusters, id))
+		if err != nil {
+			logs.Warn("Keep pod metrics record %q of %s as it is: %v", metrics[i], id, err)
+			continue
+		}
+		if record == metrics[i] {
+			continue
+		}
+		if _, err := tx.Exec(`DELETE FROM pod_metrics_records WHERE id = ? AND metric_hash = ?`, id, metricHash(metrics[i])); err != nil {
+			return err
+		}
+		if err := insertRecords(tx, id, []string{record}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// legacyClusters returns the clusters listed in the config file or the clusters file
+// of cfg.
+func legacyClusters(cfg *config.Config) (map[string]*DataSource, error) {
+	if cfg.ClustersFile == "" {
+		return cfg.Clusters, nil
+	}
+	return readClusters(cfg.ClustersFile)
+}
+
+// legacyLabels returns the pod labels of the cluster of id, which is the longest of
+// clusters prefixing id. The labels of cfg are returned if the cluster is not listed.
+func legacyLabels(cfg *config.Config, clusters map[string]*DataSource, id string) config.LabelsConfig {
+	var cluster string
+	for name := range clusters {
+		if strings.HasPrefix(id, name+"-") && len(name) > len(cluster) {
+			cluster = name
+		}
+	}
+	if cluster == "" {
+		return cfg.Labels
+	}
+	return cfg.Labels.Override(clusters[cluster].Labels)
+}
+
+// normalizeLegacyRecord returns the normalized form of a record saved with the pod
+// matchers, which are the last two matchers, named by labels and matching the namespace
+// and the pod in id.
+func normalizeLegacyRecord(id, metric string, labels config.LabelsConfig) (string, error) {
+	s, err := promql.ParseSelector(metric)
+	if err != nil {
+		return "", err
+	}
+
+	if n := len(s.Matchers); n >= 2 {
+		namespace, pod := s.Matchers[n-2], s.Matchers[n-1]
+		if namespace.Name == labels.Namespace && namespace.Type == promql.MatchEqual &&
+			pod.Name == labels.PodName && pod.Type == promql.MatchEqual &&
+			strings.HasSuffix(id, "-"+namespace.Value+"-"+pod.Value) {
+			s = promql.NewSelector(s.Name, s.Matchers[:n-2]...)
+		}
+	}
+	if err := s.Validate(); err != nil {
+		return "", err
+	}
+
+	return recordString(s), nil
+}
+
+// Close closes the connections to the database.
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+func metricHash(metric string) string {
+	h := sha256.Sum256([]byte(metric))
+	return hex.EncodeToString(h[:])
+}
+
+func (s *sqlStore) GetPodMetricsRecords(id string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT metric FROM pod_metrics_records WHERE id = ? ORDER BY metric`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []string
+	for rows.Next() {
+		var metric string
+		if err := rows.Scan(&metric); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, rows.Err()
+}
+
+func (s *sqlStore) AddPodMetricsRecords(id string, metrics []string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		return insertRecords(tx, id, metrics)
+	})
+}
+
+func (s *sqlStore) DeletePodMetricsRecords(id string, metrics []string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		for _, metric := range metrics {
+			if _, err := tx.Exec(`DELETE FROM pod_metrics_records WHERE id = ? AND metric_hash = ?`, id, metricHash(metric)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) ResetPodMetricsRecords(id string, metrics []string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM pod_metrics_records WHERE id = ?`, id); err != nil {
+			return err
+		}
+		return insertRecords(tx, id, metrics)
+	})
+}
+
+// insertRecords inserts metrics of id, the existing ones are replaced, so it is
+// idempotent without the dialect specific "INSERT IGNORE".
+func insertRecords(tx *sql.Tx, id string, metrics []string) error {
+	for _, metric := range metrics {
+		hash := metricHash(metric)
+		if _, err := tx.Exec(`DELETE FROM pod_metrics_records WHERE id = ? AND metric_hash = ?`, id, hash); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO pod_metrics_records (id, metric_hash, metric) VALUES (?, ?, ?)`, id, hash, metric); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) inTx(f func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
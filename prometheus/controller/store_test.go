@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testStore is the contract every Store implementation must pass, store should have
+// no records of the ids prefixed with prefix.
+func testStore(t *testing.T, store Store, prefix string) {
+	id := prefix + "cluster-namespace-pod"
+	other := prefix + "cluster-namespace-other"
+
+	expect := func(id string, want []string) {
+		t.Helper()
+		got, err := store.GetPodMetricsRecords(id)
+		if err != nil {
+			t.Fatalf("GetPodMetricsRecords(%q) failed: %v", id, err)
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if len(got) == 0 && len(want) == 0 {
+			return
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("GetPodMetricsRecords(%q) = %v, want %v", id, got, want)
+		}
+	}
+
+	expect(id, nil)
+
+	if err := store.AddPodMetricsRecords(id, []string{"a", "b"}); err != nil {
+		t.Fatalf("AddPodMetricsRecords failed: %v", err)
+	}
+	// Adding an existing metric is not an error.
+	if err := store.AddPodMetricsRecords(id, []string{"b", "c"}); err != nil {
+		t.Fatalf("AddPodMetricsRecords failed: %v", err)
+	}
+	if err := store.AddPodMetricsRecords(other, []string{"x"}); err != nil {
+		t.Fatalf("AddPodMetricsRecords failed: %v", err)
+	}
+	expect(id, []string{"a", "b", "c"})
+
+	// Deleting a missing metric is not an error.
+	if err := store.DeletePodMetricsRecords(id, []string{"a", "missing"}); err != nil {
+		t.Fatalf("DeletePodMetricsRecords failed: %v", err)
+	}
+	expect(id, []string{"b", "c"})
+
+	if err := store.ResetPodMetricsRecords(id, []string{"d", "e"}); err != nil {
+		t.Fatalf("ResetPodMetricsRecords failed: %v", err)
+	}
+	expect(id, []string{"d", "e"})
+
+	if err := store.ResetPodMetricsRecords(id, nil); err != nil {
+		t.Fatalf("ResetPodMetricsRecords failed: %v", err)
+	}
+	expect(id, nil)
+
+	// Records of other ids are never touched.
+	expect(other, []string{"x"})
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore(), "")
+}
+
+func TestMemoryStoreConcurrency(t *testing.T) {
+	store := NewMemoryStore()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			metric := fmt.Sprintf("metric%d", i)
+			for j := 0; j < 100; j++ {
+				store.AddPodMetricsRecords("id", []string{metric})
+				store.GetPodMetricsRecords("id")
+				store.DeletePodMetricsRecords("id", []string{metric})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if metrics, _ := store.GetPodMetricsRecords("id"); len(metrics) != 0 {
+		t.Errorf("GetPodMetricsRecords = %v, want none", metrics)
+	}
+}
+
+// TestSQLStore runs against the MySQL specified by environment variable
+// STORE_TEST_MYSQL_DSN, e.g. "root:123456@tcp(127.0.0.1:3306)/test".
+func TestSQLStore(t *testing.T) {
+	dsn := os.Getenv("STORE_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("STORE_TEST_MYSQL_DSN is not set")
+	}
+
+	store, err := NewSQLStore("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.(*sqlStore).Close()
+
+	// Migrating an up to date database is a no-op, even if the replicas start together.
+	var wg sync.WaitGroup
+	stores := make([]Store, 4)
+	errs := make([]error, len(stores))
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stores[i], errs[i] = NewSQLStore("mysql", dsn)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err == nil {
+			defer stores[i].(*sqlStore).Close()
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testStore(t, store, fmt.Sprintf("%d-", time.Now().UnixNano()))
+}
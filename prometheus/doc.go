@@ -2,5 +2,10 @@ package main
 
 // Test command could be like:
 // curl "http://localhost:8080/backend/prometheus/clusters/ca/namespaces/na/pods/pa?start=1556018614&end=1556018914&step=15s"
+// curl "http://localhost:8080/backend/prometheus/clusters/ca/nodes/10.32.0.1?start=1556018614&end=1556018914&step=15s"
+// curl "http://localhost:8080/backend/prometheus/clusters/ca/namespaces/na/query?query=sum(up)"
+// curl -H "Accept: text/csv" "http://localhost:8080/backend/prometheus/clusters/ca/namespaces/na/pods/pa?start=1556018614&end=1556018914&step=15s"
+// curl "http://localhost:8080/backend/prometheus/clusters/ca/namespaces/na/pods/pa/series?start=1556018614&end=1556018914&step=15s&format=openmetrics"
+// curl -H "Authorization: Bearer $TOKEN" "http://localhost:8080/backend/prometheus/clusters/ca/namespaces/na/pods"
 //
 // We could get the timestamp by time.Unix()
@@ -1,11 +1,32 @@
 package main
 
 import (
-	_ "github.com/YaoZengzeng/practice/prometheus/router"
+	"flag"
+	"os"
+
+	"github.com/YaoZengzeng/practice/prometheus/config"
+	"github.com/YaoZengzeng/practice/prometheus/metrics"
+	"github.com/YaoZengzeng/practice/prometheus/router"
 
 	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
 )
 
 func main() {
-	beego.Run()
+	flag.Parse()
+
+	if err := config.Load(); err != nil {
+		logs.Error("Load config failed: %v", err)
+		os.Exit(1)
+	}
+	go config.Watch(nil)
+
+	if err := routers.Init(); err != nil {
+		logs.Error("Init routers failed: %v", err)
+		os.Exit(1)
+	}
+
+	metrics.Init(config.Get().MetricsAddress)
+
+	beego.RunWithMiddleWares(config.Get().ListenAddress, metrics.InstrumentHandler)
 }
@@ -0,0 +1,191 @@
+// Package metrics defines the metrics of the backend itself, which are served on a
+// separate listener to be scraped by Prometheus.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/astaxie/beego"
+	beecontext "github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/logs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "prometheus_backend"
+
+var (
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Total number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by route and method.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"route", "method"})
+
+	RequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "Number of HTTP requests being served.",
+	})
+
+	UpstreamQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_query_duration_seconds",
+		Help:      "Latency of the queries to the Prometheus of clusters by cluster and endpoint.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"cluster", "endpoint"})
+
+	UpstreamQueryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_query_errors_total",
+		Help:      "Total number of failed queries to the Prometheus of clusters by cluster, endpoint and error type.",
+	}, []string{"cluster", "endpoint", "type"})
+
+	UpstreamQueriesInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_queries_in_flight",
+		Help:      "Number of queries to the Prometheus of clusters being executed.",
+	}, []string{"cluster"})
+
+	StoreOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_operation_duration_seconds",
+		Help:      "Latency of the Store operations by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	StoreOperationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "store_operation_errors_total",
+		Help:      "Total number of failed Store operations by operation.",
+	}, []string{"operation"})
+
+	ResultsCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "results_cache_requests_total",
+		Help:      "Total number of chunks looked up in the results cache by result, hit or miss.",
+	}, []string{"result"})
+
+	RateLimitedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Total number of requests rejected by the rate limits by limits and scope, user or cluster.",
+	}, []string{"limits", "scope"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		RequestsTotal,
+		RequestDuration,
+		RequestsInFlight,
+		UpstreamQueryDuration,
+		UpstreamQueryErrors,
+		UpstreamQueriesInFlight,
+		StoreOperationDuration,
+		StoreOperationErrors,
+		ResultsCacheRequests,
+		RateLimitedRequests,
+	)
+}
+
+type routeKey struct{}
+
+// InstrumentHandler is a beego.MiddleWare which instruments the requests, the
+// routes are recorded by the filter inserted by Init.
+func InstrumentHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RequestsInFlight.Inc()
+		defer RequestsInFlight.Dec()
+
+		start := time.Now()
+		route := new(string)
+		rw := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), routeKey{}, route)))
+
+		// Avoid the unbounded cardinality of unmatched paths.
+		if *route == "" {
+			*route = "unmatched"
+		}
+		RequestsTotal.WithLabelValues(*route, r.Method, strconv.Itoa(rw.code)).Inc()
+		RequestDuration.WithLabelValues(*route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder records the status code written to the wrapped http.ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.code = code
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// setRoute records pattern as the route of the request for InstrumentHandler.
+func setRoute(ctx *beecontext.Context, pattern string) {
+	if route, ok := ctx.Request.Context().Value(routeKey{}).(*string); ok {
+		*route = pattern
+	}
+}
+
+// recordRoute records the pattern of the matched route for InstrumentHandler.
+func recordRoute(ctx *beecontext.Context) {
+	if pattern, ok := ctx.Input.GetData("RouterPattern").(string); ok {
+		setRoute(ctx, pattern)
+	}
+}
+
+// RouteFilter returns the filter which records pattern as the route of the requests.
+// The pattern of the matched route is only known after BeforeExec, so the requests
+// rejected by the filters at BeforeExec, e.g. those of auth and rate limits, are
+// recorded by this filter inserted at BeforeExec with the pattern of the route before
+// them.
+func RouteFilter(pattern string) beego.FilterFunc {
+	return func(ctx *beecontext.Context) {
+		setRoute(ctx, pattern)
+	}
+}
+
+// Init inserts the filter which records the routes of the requests, and serves the
+// metrics on addr if it's not empty.
+func Init(addr string) {
+	// The filter should run even if the response has been written.
+	beego.InsertFilter("*", beego.FinishRouter, recordRoute, false)
+
+	if addr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logs.Error("Serve metrics failed: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	beecontext "github.com/astaxie/beego/context"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatalf("Write metric failed: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	handler := InstrumentHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Simulate the routing of beego.
+		ctx := beecontext.NewContext()
+		ctx.Reset(w, r)
+		if r.URL.Path == "/pods/foo" {
+			ctx.Input.SetData("RouterPattern", "/pods/:pod")
+		}
+		recordRoute(ctx)
+
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	for _, path := range []string{"/pods/foo", "/unknown"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	if v := counterValue(t, RequestsTotal.WithLabelValues("/pods/:pod", "GET", "404")); v != 1 {
+		t.Errorf("expected 1 request of /pods/:pod, got %v", v)
+	}
+	if v := counterValue(t, RequestsTotal.WithLabelValues("unmatched", "GET", "404")); v != 1 {
+		t.Errorf("expected 1 unmatched request, got %v", v)
+	}
+}
+
+func TestRouteFilter(t *testing.T) {
+	handler := InstrumentHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Simulate a request rejected by a filter at BeforeExec, before beego sets
+		// the pattern of the route.
+		ctx := beecontext.NewContext()
+		ctx.Reset(w, r)
+		RouteFilter("/rules/:rule")(ctx)
+		ctx.Output.SetStatus(http.StatusForbidden)
+		ctx.Output.Body([]byte("forbidden"))
+		recordRoute(ctx)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/rules/foo", nil))
+
+	if v := counterValue(t, RequestsTotal.WithLabelValues("/rules/:rule", "DELETE", "403")); v != 1 {
+		t.Errorf("expected 1 rejected request of /rules/:rule, got %v", v)
+	}
+}
@@ -0,0 +1,8 @@
+// Package promql implements the subset of PromQL parsing needed by the backend to
+// scope user supplied queries, e.g. enforcing label matchers on every vector selector.
+//
+// The expressions are parsed and type checked like Prometheus does, but the values
+// are not evaluated, e.g. the regular expressions of the matchers are still validated
+// by Prometheus. It never accepts a query it doesn't understand, so no vector selector
+// could escape the enforcement.
+package promql
@@ -0,0 +1,139 @@
+package promql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// insertion is a string to be inserted at pos of the query.
+type insertion struct {
+	pos int
+	val string
+}
+
+// EnforceMatchers adds matchers to every vector selector of the PromQL expression, so
+// the expression could only select the samples matching them. The matchers which a
+// selector already has are not added again, so enforcing them twice changes nothing.
+// It returns an error if the expression could not be parsed.
+func EnforceMatchers(expr string, matchers ...*Matcher) (string, error) {
+	for _, m := range matchers {
+		if err := m.Validate(); err != nil {
+			return "", err
+		}
+	}
+
+	items, err := lex(expr)
+	if err != nil {
+		return "", err
+	}
+
+	selectors, err := parse(items)
+	if err != nil {
+		return "", err
+	}
+
+	var insertions []insertion
+	for _, s := range selectors {
+		var missing []*Matcher
+		for _, m := range matchers {
+			if !hasMatcher(s.matchers, m) {
+				missing = append(missing, m)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		enforce := MatchersString(missing)
+
+		switch {
+		case s.lbrace < 0:
+			insertions = append(insertions, insertion{s.name.end, "{" + enforce + "}"})
+		case s.lbrace+1 == s.rbrace:
+			insertions = append(insertions, insertion{items[s.lbrace].end, enforce})
+		default:
+			insertions = append(insertions, insertion{items[s.lbrace].end, enforce + ", "})
+		}
+	}
+
+	sort.Slice(insertions, func(i, j int) bool {
+		return insertions[i].pos < insertions[j].pos
+	})
+
+	var b strings.Builder
+	last := 0
+	for _, ins := range insertions {
+		b.WriteString(expr[last:ins.pos])
+		b.WriteString(ins.val)
+		last = ins.pos
+	}
+	b.WriteString(expr[last:])
+
+	return b.String(), nil
+}
+
+func hasMatcher(matchers []*Matcher, m *Matcher) bool {
+	for _, matcher := range matchers {
+		if *matcher == *m {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns an error if the PromQL expression could not be parsed, or its
+// operands have the wrong types, e.g. a function is called with wrong arguments.
+func Validate(expr string) error {
+	if strings.TrimSpace(expr) == "" {
+		return fmt.Errorf("empty expression")
+	}
+
+	items, err := lex(expr)
+	if err != nil {
+		return err
+	}
+
+	_, err = parse(items)
+	return err
+}
+
+// parseMatchers parses the label matchers starting from the left brace at items[lbrace],
+// and returns them with the index of the right brace.
+func parseMatchers(items []item, lbrace int) ([]*Matcher, int, error) {
+	var matchers []*Matcher
+
+	i := lbrace + 1
+	for {
+		if i >= len(items) {
+			return nil, 0, fmt.Errorf("unclosed left brace at position %d", items[lbrace].pos)
+		}
+		if items[i].typ == itemRightBrace {
+			return matchers, i, nil
+		}
+
+		if i+2 >= len(items) || items[i].typ != itemIdentifier || items[i+1].typ != itemOperator || items[i+2].typ != itemString {
+			return nil, 0, fmt.Errorf("invalid label matcher at position %d", items[i].pos)
+		}
+
+		m := &Matcher{
+			Type: MatchType(items[i+1].val),
+			Name: items[i].val,
+		}
+		if err := m.Validate(); err != nil {
+			return nil, 0, fmt.Errorf("invalid label matcher at position %d: %v", items[i].pos, err)
+		}
+		value, err := unquote(items[i+2].val)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid label matcher at position %d: %v", items[i].pos, err)
+		}
+		m.Value = value
+		matchers = append(matchers, m)
+
+		i += 3
+		if i < len(items) && items[i].typ == itemComma {
+			i++
+		} else if i < len(items) && items[i].typ != itemRightBrace {
+			return nil, 0, fmt.Errorf("expected comma or right brace at position %d", items[i].pos)
+		}
+	}
+}
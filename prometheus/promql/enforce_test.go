@@ -0,0 +1,190 @@
+package promql
+
+import (
+	"testing"
+)
+
+func TestEnforceMatchers(t *testing.T) {
+	ns := NewEqualMatcher("kubernetes_namespace", "default")
+
+	tc := []struct {
+		expr string
+		want string
+	}{
+		{
+			`up`,
+			`up{kubernetes_namespace="default"}`,
+		},
+		{
+			`up{job="prometheus"}`,
+			`up{kubernetes_namespace="default", job="prometheus"}`,
+		},
+		{
+			`{__name__=~"up|down",}`,
+			`{kubernetes_namespace="default", __name__=~"up|down",}`,
+		},
+		{
+			`up{}`,
+			`up{kubernetes_namespace="default"}`,
+		},
+		{
+			`sum by (pod) (rate(http_requests_total[5m] offset 1h))`,
+			`sum by (pod) (rate(http_requests_total{kubernetes_namespace="default"}[5m] offset 1h))`,
+		},
+		{
+			`sum(rate(x[5m])) without (instance) / on(job) group_left(pod) y`,
+			`sum(rate(x{kubernetes_namespace="default"}[5m])) without (instance) / on(job) group_left(pod) y{kubernetes_namespace="default"}`,
+		},
+		{
+			`a * ignoring(b) group_right c`,
+			`a{kubernetes_namespace="default"} * ignoring(b) group_right c{kubernetes_namespace="default"}`,
+		},
+		{
+			`max_over_time(deriv(rate(x[1m])[5m:1m])[10m:]) > bool 1e-3`,
+			`max_over_time(deriv(rate(x{kubernetes_namespace="default"}[1m])[5m:1m])[10m:]) > bool 1e-3`,
+		},
+		{
+			`label_replace(up, "foo", "$1", "job", "(.*)") # comment with up`,
+			`label_replace(up{kubernetes_namespace="default"}, "foo", "$1", "job", "(.*)") # comment with up`,
+		},
+		{
+			`x:rate5m{a='b\'c'} or ignoring(a) -y and z unless w`,
+			`x:rate5m{kubernetes_namespace="default", a='b\'c'} or ignoring(a) -y{kubernetes_namespace="default"} and z{kubernetes_namespace="default"} unless w{kubernetes_namespace="default"}`,
+		},
+		{
+			`topk(5, count_values("v", x))`,
+			`topk(5, count_values("v", x{kubernetes_namespace="default"}))`,
+		},
+		{
+			`up{kubernetes_namespace="default"} / up{job="a", kubernetes_namespace="other"}`,
+			`up{kubernetes_namespace="default"} / up{kubernetes_namespace="default", job="a", kubernetes_namespace="other"}`,
+		},
+	}
+
+	for _, c := range tc {
+		got, err := EnforceMatchers(c.expr, ns)
+		if err != nil {
+			t.Errorf("EnforceMatchers(%q) failed: %v", c.expr, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("EnforceMatchers(%q) = %q, want %q", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestEnforceMatchersInvalid(t *testing.T) {
+	ns := NewEqualMatcher("kubernetes_namespace", "default")
+
+	for _, expr := range []string{
+		`up{job="a"`,
+		`up{job}`,
+		`up{job=a}`,
+		`up{"job"="a"}`,
+		`sum(up`,
+		`up)`,
+		`rate(up[5m)`,
+		`up}`,
+		`up{job="a}`,
+		`up @ 100`,
+		`sum by ({a="b"}) (up)`,
+	} {
+		if got, err := EnforceMatchers(expr, ns); err == nil {
+			t.Errorf("EnforceMatchers(%q) = %q, want error", expr, got)
+		}
+	}
+
+	if _, err := EnforceMatchers("up", NewEqualMatcher("bad-name", "x")); err == nil {
+		t.Errorf("invalid label name of enforced matcher should be rejected")
+	}
+}
+
+func TestMatcherString(t *testing.T) {
+	m := NewEqualMatcher("kubernetes_pod_name", `a"} or vector(1) #`)
+	if got, want := m.String(), `kubernetes_pod_name="a\"} or vector(1) #"`; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, expr := range []string{
+		`up`,
+		`-up`,
+		`1 + 2 * 3 ^ -2`,
+		`up == bool 1`,
+		`1 > bool 2`,
+		`sum by (job) (rate(http_requests_total[5m])) / ignoring(code) group_left sum(x)`,
+		`SUM(up) WITHOUT (instance)`,
+		`count_values("v", up) and on() vector(1)`,
+		`topk(3, up) or bottomk(3, up) unless quantile(0.9, up)`,
+		`histogram_quantile(0.99, sum by (le) (rate(x_bucket[5m])))`,
+		`label_join(up, "a", ",", "b", "c", "d")`,
+		`round(up)`,
+		`round(up, 0.5)`,
+		`time() - timestamp(up offset 1h)`,
+		`max_over_time(rate(x[1m])[1h:5m] offset 1d)`,
+		`avg_over_time(up[1h30m:])`,
+		`{__name__=~"up|down", job!=""}`,
+		`Inf > bool NaN`,
+		`0x1f + 1e-3 + .5`,
+		`up atan2 up`,
+		`(up)`,
+	} {
+		if err := Validate(expr); err != nil {
+			t.Errorf("Validate(%q) failed: %v", expr, err)
+		}
+	}
+}
+
+func TestValidateInvalid(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`up +`,
+		`up up`,
+		`rate(up)`,
+		`== 1`,
+		`foo bar baz`,
+		`up{a="b"} offset`,
+		`up offset 5m offset 5m`,
+		`up offset 5m[5m]`,
+		`(up)[5m]`,
+		`up[5m][10m:]`,
+		`sum(up) offset 5m`,
+		`1 > 2`,
+		`up + bool up`,
+		`1 and up`,
+		`up and on(a) group_left up`,
+		`1 * on(a) up`,
+		`up[5m] + 1`,
+		`-up[5m]`,
+		`"a" + 1`,
+		`abs()`,
+		`abs(up, up)`,
+		`round(up, 1, 2)`,
+		`vector(up)`,
+		`label_join(up, "a")`,
+		`unknown(up)`,
+		`topk(up)`,
+		`topk("a", up)`,
+		`count_values(up)`,
+		`sum(up[5m])`,
+		`sum`,
+		`sum by (job)`,
+		`sum by (a b) (up)`,
+		`{}`,
+		`{job=~".*"}`,
+		`up{__name__="up"}`,
+		`rate(up[5])`,
+		`rate(up[5m:1])`,
+		`1.2.3`,
+		`by`,
+		`up offset`,
+		`up ^`,
+		`(up`,
+		`rate(up[5m]`,
+	} {
+		if err := Validate(expr); err == nil {
+			t.Errorf("Validate(%q) should fail", expr)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package promql
+
+import (
+	"fmt"
+	"strings"
+)
+
+type itemType int
+
+const (
+	itemIdentifier itemType = iota
+	itemString
+	itemNumber
+	itemOperator
+	itemLeftParen
+	itemRightParen
+	itemLeftBrace
+	itemRightBrace
+	itemLeftBracket
+	itemRightBracket
+	itemComma
+	itemColon
+)
+
+// item is a token of PromQL, pos and end are the byte offsets of it in the input.
+type item struct {
+	typ itemType
+	val string
+	pos int
+	end int
+}
+
+// operators are sorted so that the longer ones are tried first.
+var operators = []string{"==", "!=", "<=", ">=", "=~", "!~", "=", "<", ">", "+", "-", "*", "/", "%", "^"}
+
+var punctuations = map[byte]itemType{
+	'(': itemLeftParen,
+	')': itemRightParen,
+	'{': itemLeftBrace,
+	'}': itemRightBrace,
+	'[': itemLeftBracket,
+	']': itemRightBracket,
+	',': itemComma,
+}
+
+func isAlpha(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isAlphaNumeric(c byte) bool {
+	return isAlpha(c) || isDigit(c)
+}
+
+// lex splits input into items. It is deliberately strict: any character which is not
+// part of the PromQL syntax we understand is an error, so nothing could be hidden from
+// the callers which rewrite the query.
+func lex(input string) ([]item, error) {
+	var items []item
+	brackets := 0
+
+	for pos := 0; pos < len(input); {
+		c := input[pos]
+		start := pos
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			pos++
+			continue
+
+		case c == '#':
+			// Comment until the end of line.
+			for pos < len(input) && input[pos] != '\n' {
+				pos++
+			}
+			continue
+
+		case c == '"' || c == '\'':
+			pos++
+			for {
+				if pos >= len(input) || input[pos] == '\n' {
+					return nil, fmt.Errorf("unterminated quoted string at position %d", start)
+				}
+				if input[pos] == '\\' {
+					pos += 2
+					continue
+				}
+				if input[pos] == c {
+					pos++
+					break
+				}
+				pos++
+			}
+			items = append(items, item{itemString, input[start:pos], start, pos})
+
+		case c == '`':
+			end := strings.IndexByte(input[pos+1:], '`')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated raw string at position %d", start)
+			}
+			pos += end + 2
+			items = append(items, item{itemString, input[start:pos], start, pos})
+
+		case isDigit(c) || (c == '.' && pos+1 < len(input) && isDigit(input[pos+1])):
+			// Numbers and durations, e.g. 1, 1.5, 1e-3, 0x1f, 5m, 1h30m.
+			for pos < len(input) && (isAlphaNumeric(input[pos]) || input[pos] == '.') {
+				if (input[pos] == 'e' || input[pos] == 'E') && pos+1 < len(input) && (input[pos+1] == '+' || input[pos+1] == '-') && !strings.HasPrefix(strings.ToLower(input[start:pos]), "0x") {
+					pos++
+				}
+				pos++
+			}
+			items = append(items, item{itemNumber, input[start:pos], start, pos})
+
+		case isAlpha(c) || (c == ':' && brackets == 0):
+			for pos < len(input) && (isAlphaNumeric(input[pos]) || input[pos] == ':') {
+				pos++
+			}
+			items = append(items, item{itemIdentifier, input[start:pos], start, pos})
+
+		case c == ':':
+			pos++
+			items = append(items, item{itemColon, ":", start, pos})
+
+		case c == '(' || c == ')' || c == '{' || c == '}' || c == '[' || c == ']' || c == ',':
+			pos++
+			typ := punctuations[c]
+			if typ == itemLeftBracket {
+				brackets++
+			} else if typ == itemRightBracket {
+				brackets--
+			}
+			items = append(items, item{typ, input[start:pos], start, pos})
+
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(input[pos:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, pos)
+			}
+			pos += len(op)
+			items = append(items, item{itemOperator, op, start, pos})
+		}
+	}
+
+	return items, nil
+}
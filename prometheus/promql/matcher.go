@@ -0,0 +1,83 @@
+package promql
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher is a label matcher of vector selector.
+type Matcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+}
+
+// NewEqualMatcher returns a matcher selecting the samples whose label name equals value.
+func NewEqualMatcher(name, value string) *Matcher {
+	return &Matcher{
+		Type:  MatchEqual,
+		Name:  name,
+		Value: value,
+	}
+}
+
+// String returns the matcher in PromQL, the value is always quoted and escaped.
+func (m *Matcher) String() string {
+	return fmt.Sprintf("%s%s%s", m.Name, m.Type, strconv.Quote(m.Value))
+}
+
+// IsLabelName returns whether s is a valid label name.
+func IsLabelName(s string) bool {
+	if len(s) == 0 || !isAlpha(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isAlphaNumeric(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate returns an error if the name of the matcher is not a valid label name or
+// the type is unknown.
+func (m *Matcher) Validate() error {
+	if !IsLabelName(m.Name) {
+		return fmt.Errorf("invalid label name %q", m.Name)
+	}
+	switch m.Type {
+	case MatchEqual, MatchNotEqual, MatchRegexp, MatchNotRegexp:
+		return nil
+	}
+	return fmt.Errorf("invalid match type %q", m.Type)
+}
+
+// matchesEmpty returns whether the matcher matches the empty label value, which is
+// also the value of a missing label.
+func (m *Matcher) matchesEmpty() (bool, error) {
+	switch m.Type {
+	case MatchEqual:
+		return m.Value == "", nil
+	case MatchNotEqual:
+		return m.Value != "", nil
+	}
+
+	re, err := regexp.Compile("^(?:" + m.Value + ")$")
+	if err != nil {
+		return false, err
+	}
+	if m.Type == MatchRegexp {
+		return re.MatchString(""), nil
+	}
+	return !re.MatchString(""), nil
+}
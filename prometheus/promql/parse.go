@@ -0,0 +1,705 @@
+package promql
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// aggregators could be followed by "by" or "without" instead of the left paren.
+var aggregators = map[string]bool{
+	"sum":          true,
+	"min":          true,
+	"max":          true,
+	"avg":          true,
+	"group":        true,
+	"stddev":       true,
+	"stdvar":       true,
+	"count":        true,
+	"count_values": true,
+	"bottomk":      true,
+	"topk":         true,
+	"quantile":     true,
+}
+
+// groupings are followed by a parenthesized list of label names.
+var groupings = map[string]bool{
+	"by":          true,
+	"without":     true,
+	"on":          true,
+	"ignoring":    true,
+	"group_left":  true,
+	"group_right": true,
+}
+
+var keywords = map[string]bool{
+	"and":    true,
+	"or":     true,
+	"unless": true,
+	"bool":   true,
+	"offset": true,
+	"atan2":  true,
+	"inf":    true,
+	"nan":    true,
+}
+
+type valueType string
+
+const (
+	valueScalar valueType = "scalar"
+	valueVector valueType = "instant vector"
+	valueMatrix valueType = "range vector"
+	valueString valueType = "string"
+)
+
+// function is the signature of a PromQL function. variadic is the number of the
+// trailing args which could be omitted, or -1 if the last one could be repeated.
+type function struct {
+	args       []valueType
+	variadic   int
+	returnType valueType
+}
+
+var functions = map[string]function{
+	"abs":                {[]valueType{valueVector}, 0, valueVector},
+	"absent":             {[]valueType{valueVector}, 0, valueVector},
+	"absent_over_time":   {[]valueType{valueMatrix}, 0, valueVector},
+	"avg_over_time":      {[]valueType{valueMatrix}, 0, valueVector},
+	"ceil":               {[]valueType{valueVector}, 0, valueVector},
+	"changes":            {[]valueType{valueMatrix}, 0, valueVector},
+	"clamp":              {[]valueType{valueVector, valueScalar, valueScalar}, 0, valueVector},
+	"clamp_max":          {[]valueType{valueVector, valueScalar}, 0, valueVector},
+	"clamp_min":          {[]valueType{valueVector, valueScalar}, 0, valueVector},
+	"count_over_time":    {[]valueType{valueMatrix}, 0, valueVector},
+	"day_of_month":       {[]valueType{valueVector}, 1, valueVector},
+	"day_of_week":        {[]valueType{valueVector}, 1, valueVector},
+	"days_in_month":      {[]valueType{valueVector}, 1, valueVector},
+	"delta":              {[]valueType{valueMatrix}, 0, valueVector},
+	"deriv":              {[]valueType{valueMatrix}, 0, valueVector},
+	"exp":                {[]valueType{valueVector}, 0, valueVector},
+	"floor":              {[]valueType{valueVector}, 0, valueVector},
+	"histogram_quantile": {[]valueType{valueScalar, valueVector}, 0, valueVector},
+	"holt_winters":       {[]valueType{valueMatrix, valueScalar, valueScalar}, 0, valueVector},
+	"hour":               {[]valueType{valueVector}, 1, valueVector},
+	"idelta":             {[]valueType{valueMatrix}, 0, valueVector},
+	"increase":           {[]valueType{valueMatrix}, 0, valueVector},
+	"irate":              {[]valueType{valueMatrix}, 0, valueVector},
+	"label_join":         {[]valueType{valueVector, valueString, valueString, valueString}, -1, valueVector},
+	"label_replace":      {[]valueType{valueVector, valueString, valueString, valueString, valueString}, 0, valueVector},
+	"last_over_time":     {[]valueType{valueMatrix}, 0, valueVector},
+	"ln":                 {[]valueType{valueVector}, 0, valueVector},
+	"log10":              {[]valueType{valueVector}, 0, valueVector},
+	"log2":               {[]valueType{valueVector}, 0, valueVector},
+	"max_over_time":      {[]valueType{valueMatrix}, 0, valueVector},
+	"min_over_time":      {[]valueType{valueMatrix}, 0, valueVector},
+	"minute":             {[]valueType{valueVector}, 1, valueVector},
+	"month":              {[]valueType{valueVector}, 1, valueVector},
+	"predict_linear":     {[]valueType{valueMatrix, valueScalar}, 0, valueVector},
+	"present_over_time":  {[]valueType{valueMatrix}, 0, valueVector},
+	"quantile_over_time": {[]valueType{valueScalar, valueMatrix}, 0, valueVector},
+	"rate":               {[]valueType{valueMatrix}, 0, valueVector},
+	"resets":             {[]valueType{valueMatrix}, 0, valueVector},
+	"round":              {[]valueType{valueVector, valueScalar}, 1, valueVector},
+	"scalar":             {[]valueType{valueVector}, 0, valueScalar},
+	"sgn":                {[]valueType{valueVector}, 0, valueVector},
+	"sort":               {[]valueType{valueVector}, 0, valueVector},
+	"sort_desc":          {[]valueType{valueVector}, 0, valueVector},
+	"sqrt":               {[]valueType{valueVector}, 0, valueVector},
+	"stddev_over_time":   {[]valueType{valueMatrix}, 0, valueVector},
+	"stdvar_over_time":   {[]valueType{valueMatrix}, 0, valueVector},
+	"sum_over_time":      {[]valueType{valueMatrix}, 0, valueVector},
+	"time":               {nil, 0, valueScalar},
+	"timestamp":          {[]valueType{valueVector}, 0, valueVector},
+	"vector":             {[]valueType{valueScalar}, 0, valueVector},
+	"year":               {[]valueType{valueVector}, 1, valueVector},
+}
+
+// Precedences of the binary operators, the higher binds tighter.
+const (
+	precOr = iota + 1
+	precAnd
+	precComparison
+	precAdditive
+	precMultiplicative
+	precPower
+)
+
+var binaryOperators = map[string]int{
+	"or":     precOr,
+	"and":    precAnd,
+	"unless": precAnd,
+	"==":     precComparison,
+	"!=":     precComparison,
+	"<=":     precComparison,
+	">=":     precComparison,
+	"<":      precComparison,
+	">":      precComparison,
+	"+":      precAdditive,
+	"-":      precAdditive,
+	"*":      precMultiplicative,
+	"/":      precMultiplicative,
+	"%":      precMultiplicative,
+	"atan2":  precMultiplicative,
+	"^":      precPower,
+}
+
+var durationRE = regexp.MustCompile(`^([0-9]+y)?([0-9]+w)?([0-9]+d)?([0-9]+h)?([0-9]+m)?([0-9]+s)?([0-9]+ms)?$`)
+
+// selector is a vector selector found by parse. name is its metric name or nil,
+// lbrace and rbrace are the indexes of its braces in the items or -1 if it has no
+// braces.
+type selector struct {
+	name     *item
+	lbrace   int
+	rbrace   int
+	matchers []*Matcher
+}
+
+// exprKind tells which postfixes could follow an expression.
+type exprKind int
+
+const (
+	kindOther exprKind = iota
+	kindSelector
+	kindMatrixSelector
+	kindSubquery
+)
+
+// parser is a recursive descent parser of PromQL expressions. It checks the syntax
+// and the types like Prometheus does, and records the vector selectors.
+type parser struct {
+	items     []item
+	pos       int
+	selectors []selector
+}
+
+// parse parses the PromQL expression of items, and returns its vector selectors in
+// the order they appear.
+func parse(items []item) ([]selector, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+
+	p := &parser{items: items}
+	if _, err := p.parseExpr(precOr); err != nil {
+		return nil, err
+	}
+	if it := p.peek(); it != nil {
+		return nil, p.unexpected("")
+	}
+
+	return p.selectors, nil
+}
+
+func (p *parser) peek() *item {
+	if p.pos >= len(p.items) {
+		return nil
+	}
+	return &p.items[p.pos]
+}
+
+func (p *parser) next() *item {
+	it := p.peek()
+	if it != nil {
+		p.pos++
+	}
+	return it
+}
+
+// peekKeyword returns whether the next item is the keyword, which is case insensitive.
+func (p *parser) peekKeyword(keyword string) bool {
+	it := p.peek()
+	return it != nil && it.typ == itemIdentifier && strings.ToLower(it.val) == keyword
+}
+
+// unexpected returns an error for the next item, context tells what was expected.
+func (p *parser) unexpected(context string) error {
+	if context != "" {
+		context = " " + context
+	}
+	it := p.peek()
+	if it == nil {
+		return fmt.Errorf("unexpected end of expression%s", context)
+	}
+	return fmt.Errorf("unexpected %q at position %d%s", it.val, it.pos, context)
+}
+
+func (p *parser) expect(typ itemType, context string) (*item, error) {
+	if it := p.peek(); it == nil || it.typ != typ {
+		return nil, p.unexpected(context)
+	}
+	return p.next(), nil
+}
+
+// binaryOperator returns the binary operator of the next item with its precedence.
+func (p *parser) binaryOperator() (string, int, bool) {
+	it := p.peek()
+	if it == nil || (it.typ != itemOperator && it.typ != itemIdentifier) {
+		return "", 0, false
+	}
+	op := it.val
+	if it.typ == itemIdentifier {
+		op = strings.ToLower(op)
+	}
+	prec, ok := binaryOperators[op]
+	return op, prec, ok
+}
+
+// parseExpr parses the binary expressions whose operators bind at least as tight as
+// prec.
+func (p *parser) parseExpr(prec int) (valueType, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		op, opPrec, ok := p.binaryOperator()
+		if !ok || opPrec < prec {
+			return lhs, nil
+		}
+		p.next()
+
+		comparison := opPrec == precComparison
+		setOperator := opPrec == precOr || op == "and" || op == "unless"
+
+		returnBool := false
+		if p.peekKeyword("bool") {
+			if !comparison {
+				return "", fmt.Errorf("bool modifier at position %d is only allowed after comparison operators", p.peek().pos)
+			}
+			p.next()
+			returnBool = true
+		}
+
+		matching := false
+		if p.peekKeyword("on") || p.peekKeyword("ignoring") {
+			p.next()
+			if err := p.parseLabels(); err != nil {
+				return "", err
+			}
+			matching = true
+
+			if p.peekKeyword("group_left") || p.peekKeyword("group_right") {
+				if setOperator {
+					return "", fmt.Errorf("grouping at position %d is not allowed for set operator %s", p.peek().pos, op)
+				}
+				p.next()
+				if it := p.peek(); it != nil && it.typ == itemLeftParen {
+					if err := p.parseLabels(); err != nil {
+						return "", err
+					}
+				}
+			}
+		}
+
+		// "^" is right associative.
+		next := opPrec + 1
+		if opPrec == precPower {
+			next = opPrec
+		}
+		rhs, err := p.parseExpr(next)
+		if err != nil {
+			return "", err
+		}
+
+		if lhs, err = binaryType(op, lhs, rhs, comparison, setOperator, returnBool, matching); err != nil {
+			return "", err
+		}
+	}
+}
+
+// binaryType returns the type of the binary expression, or an error if its operands
+// are not allowed.
+func binaryType(op string, lhs, rhs valueType, comparison, setOperator, returnBool, matching bool) (valueType, error) {
+	for _, t := range []valueType{lhs, rhs} {
+		if t != valueScalar && t != valueVector {
+			return "", fmt.Errorf("binary expression %s must contain only scalar and instant vector types, got %s", op, t)
+		}
+	}
+
+	bothVectors := lhs == valueVector && rhs == valueVector
+	if setOperator && !bothVectors {
+		return "", fmt.Errorf("set operator %s not allowed in binary scalar expression", op)
+	}
+	if matching && !bothVectors {
+		return "", fmt.Errorf("vector matching only allowed between instant vectors")
+	}
+	if comparison && !returnBool && lhs == valueScalar && rhs == valueScalar {
+		return "", fmt.Errorf("comparisons between scalars must use bool modifier")
+	}
+
+	if lhs == valueVector || rhs == valueVector {
+		return valueVector, nil
+	}
+	return valueScalar, nil
+}
+
+// parseUnary parses an expression with optional unary operators, which bind looser
+// than "^" like in Prometheus.
+func (p *parser) parseUnary() (valueType, error) {
+	it := p.peek()
+	if it != nil && it.typ == itemOperator && (it.val == "+" || it.val == "-") {
+		p.next()
+		t, err := p.parseExpr(precPower)
+		if err != nil {
+			return "", err
+		}
+		if t != valueScalar && t != valueVector {
+			return "", fmt.Errorf("unary expression at position %d only allowed on expressions of type scalar or instant vector, got %s", it.pos, t)
+		}
+		return t, nil
+	}
+
+	return p.parsePostfix()
+}
+
+// parsePostfix parses an expression with optional ranges, subqueries and offsets.
+func (p *parser) parsePostfix() (valueType, error) {
+	t, kind, err := p.parsePrimary()
+	if err != nil {
+		return "", err
+	}
+
+	offset := false
+	for {
+		it := p.peek()
+		switch {
+		case it != nil && it.typ == itemLeftBracket:
+			p.next()
+			if err := p.parseDuration(); err != nil {
+				return "", err
+			}
+
+			if next := p.peek(); next != nil && next.typ == itemColon {
+				p.next()
+				if next := p.peek(); next != nil && next.typ != itemRightBracket {
+					if err := p.parseDuration(); err != nil {
+						return "", err
+					}
+				}
+				if _, err := p.expect(itemRightBracket, "in subquery"); err != nil {
+					return "", err
+				}
+				if t != valueVector {
+					return "", fmt.Errorf("subquery at position %d is only allowed on instant vector, got %s", it.pos, t)
+				}
+				t, kind, offset = valueMatrix, kindSubquery, false
+				continue
+			}
+
+			if _, err := p.expect(itemRightBracket, "in range"); err != nil {
+				return "", err
+			}
+			if kind != kindSelector {
+				return "", fmt.Errorf("range at position %d is only allowed for vector selectors", it.pos)
+			}
+			if offset {
+				return "", fmt.Errorf("range at position %d is not allowed after offset", it.pos)
+			}
+			t, kind = valueMatrix, kindMatrixSelector
+
+		case p.peekKeyword("offset"):
+			if kind == kindOther || offset {
+				return "", fmt.Errorf("offset at position %d is only allowed once after vector selectors, ranges or subqueries", it.pos)
+			}
+			p.next()
+			if err := p.parseDuration(); err != nil {
+				return "", err
+			}
+			offset = true
+
+		default:
+			return t, nil
+		}
+	}
+}
+
+// parsePrimary parses a literal, a parenthesized expression, a vector selector, a
+// function call or an aggregation.
+func (p *parser) parsePrimary() (valueType, exprKind, error) {
+	it := p.peek()
+	if it == nil {
+		return "", kindOther, p.unexpected("")
+	}
+
+	switch it.typ {
+	case itemNumber:
+		p.next()
+		if !isNumber(it.val) {
+			return "", kindOther, fmt.Errorf("invalid number %q at position %d", it.val, it.pos)
+		}
+		return valueScalar, kindOther, nil
+
+	case itemString:
+		p.next()
+		if _, err := unquote(it.val); err != nil {
+			return "", kindOther, fmt.Errorf("invalid string at position %d: %v", it.pos, err)
+		}
+		return valueString, kindOther, nil
+
+	case itemLeftParen:
+		p.next()
+		t, err := p.parseExpr(precOr)
+		if err != nil {
+			return "", kindOther, err
+		}
+		if _, err := p.expect(itemRightParen, "in parentheses"); err != nil {
+			return "", kindOther, err
+		}
+		return t, kindOther, nil
+
+	case itemLeftBrace:
+		if err := p.parseSelector(nil); err != nil {
+			return "", kindOther, err
+		}
+		return valueVector, kindSelector, nil
+
+	case itemIdentifier:
+		lower := strings.ToLower(it.val)
+		switch {
+		case lower == "inf" || lower == "nan":
+			p.next()
+			return valueScalar, kindOther, nil
+		case aggregators[lower]:
+			t, err := p.parseAggregation()
+			return t, kindOther, err
+		}
+
+		p.next()
+		if next := p.peek(); next != nil && next.typ == itemLeftParen {
+			t, err := p.parseCall(it)
+			return t, kindOther, err
+		}
+		if keywords[lower] || groupings[lower] {
+			p.pos--
+			return "", kindOther, p.unexpected("")
+		}
+
+		if err := p.parseSelector(it); err != nil {
+			return "", kindOther, err
+		}
+		return valueVector, kindSelector, nil
+	}
+
+	return "", kindOther, p.unexpected("")
+}
+
+// parseSelector parses the optional label matchers of the vector selector whose
+// metric name is name, and records the selector.
+func (p *parser) parseSelector(name *item) error {
+	s := selector{name: name, lbrace: -1, rbrace: -1}
+	var pos int
+	if name != nil {
+		pos = name.pos
+	} else {
+		pos = p.items[p.pos].pos
+	}
+
+	var matchers []*Matcher
+	if it := p.peek(); it != nil && it.typ == itemLeftBrace {
+		ms, rbrace, err := parseMatchers(p.items, p.pos)
+		if err != nil {
+			return err
+		}
+		matchers = ms
+		s.lbrace, s.rbrace, s.matchers = p.pos, rbrace, ms
+		p.pos = rbrace + 1
+	}
+
+	if name != nil {
+		if !IsMetricName(name.val) {
+			return fmt.Errorf("invalid metric name %q at position %d", name.val, name.pos)
+		}
+		for _, m := range matchers {
+			if m.Name == "__name__" {
+				return fmt.Errorf("metric name of selector at position %d is set twice", pos)
+			}
+		}
+	} else {
+		// The selector must not match every series.
+		nonEmpty := false
+		for _, m := range matchers {
+			matchesEmpty, err := m.matchesEmpty()
+			if err != nil {
+				return fmt.Errorf("invalid label matcher of selector at position %d: %v", pos, err)
+			}
+			if !matchesEmpty {
+				nonEmpty = true
+			}
+		}
+		if !nonEmpty {
+			return fmt.Errorf("vector selector at position %d must contain at least one non-empty matcher", pos)
+		}
+	}
+
+	p.selectors = append(p.selectors, s)
+	return nil
+}
+
+// parseCall parses the args of the function name and checks their types.
+func (p *parser) parseCall(name *item) (valueType, error) {
+	f, ok := functions[name.val]
+	if !ok {
+		return "", fmt.Errorf("unknown function %q at position %d", name.val, name.pos)
+	}
+
+	args, err := p.parseArgs()
+	if err != nil {
+		return "", err
+	}
+
+	min, max := len(f.args), len(f.args)
+	switch {
+	case f.variadic < 0:
+		max = -1
+	case f.variadic > 0:
+		min -= f.variadic
+	}
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		return "", fmt.Errorf("wrong number of arguments for function %s at position %d, got %d", name.val, name.pos, len(args))
+	}
+
+	for i, t := range args {
+		want := f.args[len(f.args)-1]
+		if i < len(f.args) {
+			want = f.args[i]
+		}
+		if t != want {
+			return "", fmt.Errorf("argument %d of function %s at position %d should be %s, got %s", i+1, name.val, name.pos, want, t)
+		}
+	}
+
+	return f.returnType, nil
+}
+
+// parseArgs parses a parenthesized list of expressions separated by commas.
+func (p *parser) parseArgs() ([]valueType, error) {
+	if _, err := p.expect(itemLeftParen, ""); err != nil {
+		return nil, err
+	}
+
+	var args []valueType
+	if it := p.peek(); it != nil && it.typ == itemRightParen {
+		p.next()
+		return args, nil
+	}
+	for {
+		t, err := p.parseExpr(precOr)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, t)
+
+		it := p.next()
+		if it == nil {
+			return nil, fmt.Errorf("unclosed left parenthesis")
+		}
+		switch it.typ {
+		case itemComma:
+			continue
+		case itemRightParen:
+			return args, nil
+		}
+		p.pos--
+		return nil, p.unexpected("in argument list")
+	}
+}
+
+// parseAggregation parses an aggregation, whose grouping could be before or after
+// its args.
+func (p *parser) parseAggregation() (valueType, error) {
+	name := p.next()
+	op := strings.ToLower(name.val)
+
+	grouped := false
+	if p.peekKeyword("by") || p.peekKeyword("without") {
+		p.next()
+		if err := p.parseLabels(); err != nil {
+			return "", err
+		}
+		grouped = true
+	}
+
+	if it := p.peek(); it == nil || it.typ != itemLeftParen {
+		return "", p.unexpected("in aggregation " + name.val)
+	}
+	args, err := p.parseArgs()
+	if err != nil {
+		return "", err
+	}
+
+	if !grouped && (p.peekKeyword("by") || p.peekKeyword("without")) {
+		p.next()
+		if err := p.parseLabels(); err != nil {
+			return "", err
+		}
+	}
+
+	want := []valueType{valueVector}
+	switch op {
+	case "count_values":
+		want = []valueType{valueString, valueVector}
+	case "topk", "bottomk", "quantile":
+		want = []valueType{valueScalar, valueVector}
+	}
+	if len(args) != len(want) {
+		return "", fmt.Errorf("wrong number of arguments for aggregation %s at position %d, got %d", name.val, name.pos, len(args))
+	}
+	for i, t := range args {
+		if t != want[i] {
+			return "", fmt.Errorf("argument %d of aggregation %s at position %d should be %s, got %s", i+1, name.val, name.pos, want[i], t)
+		}
+	}
+
+	return valueVector, nil
+}
+
+// parseLabels parses a parenthesized list of label names.
+func (p *parser) parseLabels() error {
+	if _, err := p.expect(itemLeftParen, "before label names"); err != nil {
+		return err
+	}
+
+	for {
+		it := p.next()
+		if it == nil {
+			return fmt.Errorf("unclosed left parenthesis")
+		}
+		if it.typ == itemRightParen {
+			return nil
+		}
+		if it.typ != itemIdentifier || !IsLabelName(it.val) {
+			p.pos--
+			return p.unexpected("in label names")
+		}
+
+		if next := p.peek(); next != nil && next.typ == itemComma {
+			p.next()
+		} else if next == nil || next.typ != itemRightParen {
+			return p.unexpected("in label names")
+		}
+	}
+}
+
+// parseDuration parses a duration, e.g. 5m or 1h30m.
+func (p *parser) parseDuration() error {
+	it, err := p.expect(itemNumber, "instead of duration")
+	if err != nil {
+		return err
+	}
+	if !durationRE.MatchString(it.val) {
+		return fmt.Errorf("invalid duration %q at position %d", it.val, it.pos)
+	}
+	return nil
+}
+
+// isNumber returns whether s is a valid number literal, decimal or hexadecimal.
+func isNumber(s string) bool {
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return true
+	}
+	_, err := strconv.ParseInt(s, 0, 64)
+	return err == nil
+}
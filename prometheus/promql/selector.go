@@ -0,0 +1,156 @@
+package promql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Selector is a vector selector, the metric name is optional. It composes the
+// matchers instead of concatenating strings, so the label values are always quoted
+// and escaped.
+type Selector struct {
+	Name     string
+	Matchers []*Matcher
+}
+
+// NewSelector returns a selector of the metric name with matchers.
+func NewSelector(name string, matchers ...*Matcher) *Selector {
+	return &Selector{
+		Name:     name,
+		Matchers: matchers,
+	}
+}
+
+// With returns a copy of the selector with matchers added.
+func (s *Selector) With(matchers ...*Matcher) *Selector {
+	ms := make([]*Matcher, 0, len(s.Matchers)+len(matchers))
+	ms = append(ms, s.Matchers...)
+	ms = append(ms, matchers...)
+	return &Selector{
+		Name:     s.Name,
+		Matchers: ms,
+	}
+}
+
+// Sorted returns a copy of the selector with the matchers sorted and deduplicated, so
+// the equivalent selectors have the same string.
+func (s *Selector) Sorted() *Selector {
+	ms := make([]*Matcher, 0, len(s.Matchers))
+	ms = append(ms, s.Matchers...)
+	sort.Slice(ms, func(i, j int) bool {
+		if ms[i].Name != ms[j].Name {
+			return ms[i].Name < ms[j].Name
+		}
+		if ms[i].Type != ms[j].Type {
+			return ms[i].Type < ms[j].Type
+		}
+		return ms[i].Value < ms[j].Value
+	})
+
+	sorted := &Selector{Name: s.Name}
+	for i, m := range ms {
+		if i > 0 && *m == *ms[i-1] {
+			continue
+		}
+		sorted.Matchers = append(sorted.Matchers, m)
+	}
+	return sorted
+}
+
+// Validate returns an error if the metric name or any matcher is invalid, or the
+// selector has neither metric name nor matchers.
+func (s *Selector) Validate() error {
+	if s.Name != "" && !IsMetricName(s.Name) {
+		return fmt.Errorf("invalid metric name %q", s.Name)
+	}
+	for _, m := range s.Matchers {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+	}
+	if s.Name == "" && len(s.Matchers) == 0 {
+		return fmt.Errorf("empty selector")
+	}
+	return nil
+}
+
+// String returns the selector in PromQL.
+func (s *Selector) String() string {
+	return s.Name + "{" + MatchersString(s.Matchers) + "}"
+}
+
+// MatchersString returns the matchers in PromQL separated by commas, without braces.
+func MatchersString(matchers []*Matcher) string {
+	ms := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		ms = append(ms, m.String())
+	}
+	return strings.Join(ms, ", ")
+}
+
+// IsMetricName returns whether s is a valid metric name, which may contain colons
+// unlike a label name.
+func IsMetricName(s string) bool {
+	if len(s) == 0 || !(isAlpha(s[0]) || s[0] == ':') {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !(isAlphaNumeric(s[i]) || s[i] == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseSelector parses s which must be a single vector selector, e.g. up,
+// up{job="node"} or {__name__=~"up|down"}. Anything else, like functions, operators,
+// range vectors or offsets, is rejected with the reason.
+func ParseSelector(s string) (*Selector, error) {
+	items, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("empty selector")
+	}
+
+	selector := &Selector{}
+	i := 0
+	if items[0].typ == itemIdentifier {
+		lower := strings.ToLower(items[0].val)
+		if keywords[lower] || aggregators[lower] || groupings[lower] {
+			return nil, fmt.Errorf("%q is a keyword of PromQL but not a metric name", items[0].val)
+		}
+		selector.Name = items[0].val
+		i++
+	}
+
+	if i < len(items) {
+		if items[i].typ != itemLeftBrace {
+			return nil, fmt.Errorf("unexpected %q at position %d, only a metric name with optional label matchers is allowed", items[i].val, items[i].pos)
+		}
+		matchers, rbrace, err := parseMatchers(items, i)
+		if err != nil {
+			return nil, err
+		}
+		if rbrace != len(items)-1 {
+			next := items[rbrace+1]
+			return nil, fmt.Errorf("unexpected %q at position %d after the selector", next.val, next.pos)
+		}
+		selector.Matchers = matchers
+	}
+
+	if err := selector.Validate(); err != nil {
+		return nil, err
+	}
+	if selector.Name != "" {
+		for _, m := range selector.Matchers {
+			if m.Name == "__name__" {
+				return nil, fmt.Errorf("metric name is set twice")
+			}
+		}
+	}
+
+	return selector, nil
+}
@@ -0,0 +1,135 @@
+package promql
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelector(t *testing.T) {
+	pod := []*Matcher{
+		NewEqualMatcher("namespace", "default"),
+		NewEqualMatcher("pod", `foo"} or vector(1) or up{a="`),
+	}
+
+	tc := []struct {
+		selector *Selector
+		want     string
+	}{
+		{
+			NewSelector("up"),
+			`up{}`,
+		},
+		{
+			NewSelector("container_cpu_usage_seconds_total", pod...),
+			`container_cpu_usage_seconds_total{namespace="default", pod="foo\"} or vector(1) or up{a=\""}`,
+		},
+		{
+			NewSelector("", &Matcher{Type: MatchRegexp, Name: "instance", Value: `10\.0\.0\.1(:[0-9]+)?`}),
+			`{instance=~"10\\.0\\.0\\.1(:[0-9]+)?"}`,
+		},
+		{
+			NewSelector("up", NewEqualMatcher("job", "node")).With(pod[0]),
+			`up{job="node", namespace="default"}`,
+		},
+	}
+
+	for _, c := range tc {
+		if got := c.selector.String(); got != c.want {
+			t.Errorf("String() = %s, want %s", got, c.want)
+		}
+		// The selector is a single vector selector, nothing escapes from it.
+		if got, err := EnforceMatchers(c.selector.String(), NewEqualMatcher("a", "b")); err != nil || !strings.HasPrefix(got, c.selector.Name+`{a="b"`) {
+			t.Errorf("EnforceMatchers(%s) = %s, %v", c.selector, got, err)
+		}
+	}
+}
+
+func TestSelectorWith(t *testing.T) {
+	s := NewSelector("up", NewEqualMatcher("job", "node"))
+	s.With(NewEqualMatcher("a", "b"))
+	if len(s.Matchers) != 1 {
+		t.Errorf("With should not modify the selector, got %s", s)
+	}
+}
+
+func TestSelectorSorted(t *testing.T) {
+	s := NewSelector("up",
+		NewEqualMatcher("pod", "foo"),
+		&Matcher{Type: MatchRegexp, Name: "job", Value: "node"},
+		NewEqualMatcher("job", "node"),
+		NewEqualMatcher("pod", "foo"),
+	)
+	want := `up{job="node", job=~"node", pod="foo"}`
+	if got := s.Sorted().String(); got != want {
+		t.Errorf("Sorted() = %s, want %s", got, want)
+	}
+	if len(s.Matchers) != 4 || s.Matchers[0].Name != "pod" {
+		t.Errorf("Sorted() modified the selector: %s", s)
+	}
+}
+
+func TestSelectorValidate(t *testing.T) {
+	tc := []struct {
+		selector *Selector
+		valid    bool
+	}{
+		{NewSelector("up"), true},
+		{NewSelector("job:up:sum"), true},
+		{NewSelector("", NewEqualMatcher("job", "node")), true},
+		{NewSelector(""), false},
+		{NewSelector("1up"), false},
+		{NewSelector("up-down"), false},
+		{NewSelector("up", NewEqualMatcher("a:b", "c")), false},
+		{NewSelector("up", &Matcher{Type: "==", Name: "a"}), false},
+	}
+
+	for _, c := range tc {
+		if err := c.selector.Validate(); (err == nil) != c.valid {
+			t.Errorf("Validate(%s) = %v, want valid %v", c.selector, err, c.valid)
+		}
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	tc := []struct {
+		input string
+		want  string
+		err   string
+	}{
+		{input: `up`, want: `up{}`},
+		{input: ` job:up:sum `, want: `job:up:sum{}`},
+		{input: `up{job="node", instance=~'10\\..*'}`, want: `up{job="node", instance=~"10\\..*"}`},
+		{input: `{__name__=~"up|down",}`, want: `{__name__=~"up|down"}`},
+		{input: `up{}`, want: `up{}`},
+		{input: ``, err: "empty selector"},
+		{input: `{}`, err: "empty selector"},
+		{input: `up) or (secret_metric`, err: `unexpected ")" at position 2`},
+		{input: `up{job="node"} or secret_metric`, err: `unexpected "or" at position 15`},
+		{input: `rate(up[5m])`, err: `unexpected "("`},
+		{input: `up[5m]`, err: `unexpected "["`},
+		{input: `up offset 5m`, err: `unexpected "offset"`},
+		{input: `sum`, err: "keyword"},
+		{input: `up{job="node"`, err: "unclosed left brace"},
+		{input: `up{job="node"}}`, err: `unexpected "}" at position 14`},
+		{input: `up{job=node}`, err: "invalid label matcher"},
+		{input: `up{__name__="down"}`, err: "set twice"},
+		{input: `"up"`, err: "unexpected"},
+	}
+
+	for _, c := range tc {
+		s, err := ParseSelector(c.input)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("ParseSelector(%s) error = %v, want containing %q", c.input, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSelector(%s) failed: %v", c.input, err)
+			continue
+		}
+		if got := s.String(); got != c.want {
+			t.Errorf("ParseSelector(%s) = %s, want %s", c.input, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package promql
+
+import (
+	"strconv"
+	"unicode/utf8"
+)
+
+// unquote unquotes a PromQL string, which has the same escaping as Go except that
+// single quoted strings could have more than one character.
+func unquote(s string) (string, error) {
+	if len(s) < 2 || s[0] != '\'' || s[len(s)-1] != '\'' {
+		return strconv.Unquote(s)
+	}
+
+	var b []byte
+	for rest := s[1 : len(s)-1]; len(rest) > 0; {
+		value, multibyte, tail, err := strconv.UnquoteChar(rest, '\'')
+		if err != nil {
+			return "", err
+		}
+		if value < utf8.RuneSelf || !multibyte {
+			b = append(b, byte(value))
+		} else {
+			var buf [utf8.UTFMax]byte
+			n := utf8.EncodeRune(buf[:], value)
+			b = append(b, buf[:n]...)
+		}
+		rest = tail
+	}
+
+	return string(b), nil
+}
@@ -0,0 +1,207 @@
+// Package rate implements a token bucket limiter, which refills the bucket at a
+// rate and lets the callers reserve the tokens, waiting for them or not.
+package rate
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type Limit float64
+
+func (l Limit) tokensToDuration(tokens float64) time.Duration {
+	return time.Duration(tokens / float64(l) * float64(time.Second))
+}
+
+func (l Limit) durationToTokens(d time.Duration) float64 {
+	return float64(l) * d.Seconds()
+}
+
+const Inf = Limit(math.MaxFloat64)
+
+const InfDuration = time.Duration(math.MaxInt64)
+
+func Every(interval time.Duration) Limit {
+	if interval <= 0 {
+		return Inf
+	}
+
+	return 1 / Limit(interval.Seconds())
+}
+
+type Limiter struct {
+	limit  Limit
+	tokens float64
+	burst  int
+	last   time.Time
+
+	sync.Mutex
+}
+
+func NewLimiter(r Limit, b int) *Limiter {
+	return &Limiter{
+		limit: r,
+		burst: b,
+	}
+}
+
+// Limit returns the maximum overall event rate.
+func (lim *Limiter) Limit() Limit {
+	lim.Lock()
+	defer lim.Unlock()
+
+	return lim.limit
+}
+
+// Burst returns the maximum burst size.
+func (lim *Limiter) Burst() int {
+	lim.Lock()
+	defer lim.Unlock()
+
+	return lim.burst
+}
+
+func (lim *Limiter) Allow() bool {
+	return lim.AllowN(time.Now(), 1)
+}
+
+// AllowN reports whether n events may happen at time now. Use this method if you intend to
+// drop/skip events that exceed the rate limit. Otherwise use Reserve or Wait.
+func (lim *Limiter) AllowN(now time.Time, n int) bool {
+	return lim.reserveN(now, n, 0).ok
+}
+
+// TryN is like AllowN, but the returned Reservation tells how long the caller should
+// wait before trying again if n events may not happen at time now. Nothing is reserved
+// in that case.
+func (lim *Limiter) TryN(now time.Time, n int) *Reservation {
+	return lim.reserveN(now, n, 0)
+}
+
+// ReserveN returns a Reservation that indicates how long the caller must wait before n events
+// happen. The Limiter takes this Reservation into account when allowing future events. ReserveN
+// returns false if n exceeds the Limiter's burst size.
+func (lim *Limiter) ReserveN(now time.Time, n int) *Reservation {
+	return lim.reserveN(now, n, InfDuration)
+}
+
+func (lim *Limiter) reserveN(now time.Time, n int, maxFutureTime time.Duration) *Reservation {
+	lim.Lock()
+	defer lim.Unlock()
+
+	if lim.limit == Inf {
+		return &Reservation{
+			ok:        true,
+			timeToAct: now,
+		}
+	}
+
+	now, last, tokens := lim.advance(now, n)
+
+	var waitDuration time.Duration
+	tokens -= float64(n)
+	if tokens < 0 {
+		waitDuration = lim.limit.tokensToDuration(-tokens)
+	}
+
+	ok := n <= lim.burst && waitDuration <= maxFutureTime
+
+	res := &Reservation{
+		ok:        ok,
+		lim:       lim,
+		tokens:    n,
+		timeToAct: now.Add(waitDuration),
+		exceeded:  n > lim.burst,
+	}
+
+	if ok {
+		lim.last = now
+		lim.tokens = tokens
+	} else {
+		lim.last = last
+	}
+
+	return res
+}
+
+func (lim *Limiter) advance(now time.Time, n int) (time.Time, time.Time, float64) {
+	last := lim.last
+	if now.Before(last) {
+		last = now
+	}
+
+	maxElapse := lim.limit.tokensToDuration(float64(lim.burst) - lim.tokens)
+	elapse := now.Sub(last)
+	if elapse > maxElapse {
+		elapse = maxElapse
+	}
+
+	delta := lim.limit.durationToTokens(elapse)
+	tokens := lim.tokens + delta
+
+	return now, last, tokens
+}
+
+// Reservation holds the information about the events that are permitted by a Limiter
+// after a delay.
+type Reservation struct {
+	ok        bool
+	lim       *Limiter
+	tokens    int
+	timeToAct time.Time
+	// exceeded is whether the events exceed the burst size.
+	exceeded bool
+}
+
+// OK returns whether the events are permitted.
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay is shorthand for DelayFrom(time.Now()).
+func (r *Reservation) Delay() time.Duration {
+	return r.DelayFrom(time.Now())
+}
+
+// DelayFrom returns the duration for which the caller must wait before the events
+// happen. If the Reservation isn't OK, it's the duration before the events would be
+// permitted, InfDuration means they never would be as they exceed the burst size.
+func (r *Reservation) DelayFrom(now time.Time) time.Duration {
+	if r.exceeded {
+		return InfDuration
+	}
+	delay := r.timeToAct.Sub(now)
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// CancelAt returns the tokens of the Reservation taken by TryN to the Limiter at time
+// now, as if the events didn't happen. The tokens of the later events are not
+// affected, so it should not be used with the Reservations which make callers wait.
+func (r *Reservation) CancelAt(now time.Time) {
+	if !r.ok || r.lim == nil {
+		return
+	}
+
+	lim := r.lim
+	lim.Lock()
+	defer lim.Unlock()
+
+	if lim.limit == Inf {
+		return
+	}
+
+	now, _, tokens := lim.advance(now, 0)
+	tokens += float64(r.tokens)
+	if burst := float64(lim.burst); tokens > burst {
+		tokens = burst
+	}
+	lim.last = now
+	lim.tokens = tokens
+
+	// Cancel only once.
+	r.ok = false
+}
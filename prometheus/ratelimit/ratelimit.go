@@ -0,0 +1,267 @@
+// Package ratelimit limits the rate of the requests of every caller and to every
+// cluster with token buckets, so a single caller couldn't overload the Prometheus.
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/YaoZengzeng/practice/prometheus/auth"
+	"github.com/YaoZengzeng/practice/prometheus/metrics"
+	"github.com/YaoZengzeng/practice/prometheus/ratelimit/rate"
+
+	"github.com/astaxie/beego"
+	beecontext "github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/logs"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// defaultLimits is the name of the default limits.
+	defaultLimits = "default"
+
+	// The buckets idle for idleTimeout are removed, a full bucket is the same as a
+	// new one anyway.
+	idleTimeout   = 10 * time.Minute
+	sweepInterval = time.Minute
+
+	// limitedKey marks the requests limited by the filter of a route.
+	limitedKey = "RateLimited"
+)
+
+// Limits are the rate limits of the routes, the limits file looks like:
+//
+//	default:
+//	  user:
+//	    rate: 10
+//	    burst: 20
+//	  cluster:
+//	    rate: 50
+//	    burst: 100
+//	routes:
+//	  /backend/prometheus/clusters/:cluster/namespaces/:namespace/pods/:pod/series:
+//	    user:
+//	      rate: 1
+//	      burst: 5
+//
+// The routes are the patterns registered in the router. A route inherits the user or
+// cluster limit from the default if it's omitted, and a zero rate means no limit.
+// Every route listed has its own buckets, while the other routes share the buckets of
+// the default.
+type Limits struct {
+	Default RouteLimits            `yaml:"default"`
+	Routes  map[string]RouteLimits `yaml:"routes"`
+}
+
+// RouteLimits are the limits of the requests of every caller and to every cluster.
+type RouteLimits struct {
+	User    *Limit `yaml:"user"`
+	Cluster *Limit `yaml:"cluster"`
+}
+
+// Limit is a token bucket which is refilled at Rate per second and holds Burst tokens
+// at most, every request takes a token.
+type Limit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+func (l *Limit) validate() error {
+	if l == nil {
+		return nil
+	}
+	if l.Rate < 0 || math.IsNaN(l.Rate) || math.IsInf(l.Rate, 0) {
+		return fmt.Errorf("invalid rate %v", l.Rate)
+	}
+	if l.Rate > 0 && l.Burst < 1 {
+		return fmt.Errorf("burst should be at least 1, got %d", l.Burst)
+	}
+	return nil
+}
+
+func (l *Limit) limit() rate.Limit {
+	if l.Rate == 0 {
+		return rate.Inf
+	}
+	return rate.Limit(l.Rate)
+}
+
+func (r *RouteLimits) validate() error {
+	if err := r.User.validate(); err != nil {
+		return fmt.Errorf("user: %v", err)
+	}
+	if err := r.Cluster.validate(); err != nil {
+		return fmt.Errorf("cluster: %v", err)
+	}
+	return nil
+}
+
+// inherit returns the limits whose omitted ones are filled with those of def.
+func (r RouteLimits) inherit(def RouteLimits) RouteLimits {
+	if r.User == nil {
+		r.User = def.User
+	}
+	if r.Cluster == nil {
+		r.Cluster = def.Cluster
+	}
+	return r
+}
+
+// LoadLimits reads and validates the limits file.
+func LoadLimits(filename string) (*Limits, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := &Limits{}
+	if err := yaml.UnmarshalStrict(b, limits); err != nil {
+		return nil, fmt.Errorf("parse limits file %s failed: %v", filename, err)
+	}
+
+	if err := limits.Default.validate(); err != nil {
+		return nil, fmt.Errorf("limits file %s default %v", filename, err)
+	}
+	for route, l := range limits.Routes {
+		if err := l.validate(); err != nil {
+			return nil, fmt.Errorf("limits file %s route %s %v", filename, route, err)
+		}
+	}
+
+	return limits, nil
+}
+
+// Limiter holds the token buckets of the callers and clusters.
+type Limiter struct {
+	sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow takes a token from the bucket of key at time now. The Reservation is not OK if
+// there is none, and tells the duration to wait before retrying.
+func (l *Limiter) allow(key string, limit *Limit, now time.Time) *rate.Reservation {
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Sub(l.lastSweep) > sweepInterval {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) > idleTimeout {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(limit.limit(), limit.Burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	return b.limiter.TryN(now, 1)
+}
+
+// Filter returns the filter which applies limits named name to the requests. The
+// callers are identified by their user names if they are authenticated, or their
+// IPs otherwise, so it should be inserted after the filter of auth.
+func (l *Limiter) Filter(name string, limits RouteLimits) beego.FilterFunc {
+	return func(ctx *beecontext.Context) {
+		now := time.Now()
+
+		var taken *rate.Reservation
+		if limits.User != nil {
+			caller := "ip:" + ctx.Input.IP()
+			if user := auth.User(ctx); user != nil {
+				caller = "user:" + user.Name
+			}
+			r := l.allow(name+"|user|"+caller, limits.User, now)
+			if !r.OK() {
+				logs.Warn("caller: %s, %s %s rate limited by %s", caller, ctx.Input.Method(), ctx.Input.URL(), name)
+				metrics.RateLimitedRequests.WithLabelValues(name, "user").Inc()
+				writeTooManyRequests(ctx, r.DelayFrom(now), fmt.Sprintf("too many requests of %s", caller))
+				return
+			}
+			taken = r
+		}
+
+		cluster := ctx.Input.Param(":cluster")
+		if limits.Cluster != nil && cluster != "" {
+			if r := l.allow(name+"|cluster|"+cluster, limits.Cluster, now); !r.OK() {
+				// The request is rejected, so it should not be charged to the caller.
+				if taken != nil {
+					taken.CancelAt(now)
+				}
+				logs.Warn("cluster: %s, %s %s rate limited by %s", cluster, ctx.Input.Method(), ctx.Input.URL(), name)
+				metrics.RateLimitedRequests.WithLabelValues(name, "cluster").Inc()
+				writeTooManyRequests(ctx, r.DelayFrom(now), fmt.Sprintf("too many requests to cluster %s", cluster))
+				return
+			}
+		}
+	}
+}
+
+// Init inserts the filters of limits, the routes listed are limited by their own
+// filters, and the others by the filter of the default.
+func Init(limits *Limits) {
+	l := NewLimiter()
+
+	for route, routeLimits := range limits.Routes {
+		f := l.Filter(route, routeLimits.inherit(limits.Default))
+		beego.InsertFilter(route, beego.BeforeExec, func(ctx *beecontext.Context) {
+			// Mark the request, as the patterns of the filters are matched against
+			// the URL but not the route.
+			ctx.Input.SetData(limitedKey, true)
+			f(ctx)
+		}, true, true)
+	}
+
+	f := l.Filter(defaultLimits, limits.Default)
+	beego.InsertFilter("/backend/prometheus/*", beego.BeforeExec, func(ctx *beecontext.Context) {
+		if ctx.Input.GetData(limitedKey) == nil {
+			f(ctx)
+		}
+	}, true, true)
+}
+
+func writeTooManyRequests(ctx *beecontext.Context, delay time.Duration, msg string) {
+	// Retry-After is in seconds, round it up so the retry won't be too early.
+	retryAfter := int64(math.Ceil(delay.Seconds()))
+	if delay == rate.InfDuration || retryAfter > math.MaxInt32 {
+		retryAfter = math.MaxInt32
+	}
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	b, _ := json.Marshal(map[string]string{
+		"status":    "error",
+		"errorType": "rate_limited",
+		"error":     msg,
+	})
+
+	ctx.Output.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+	ctx.Output.Header("Content-Type", "application/json")
+	ctx.Output.SetStatus(http.StatusTooManyRequests)
+	if err := ctx.Output.Body(b); err != nil {
+		logs.Error("Write response body failed: %v", err)
+	}
+}
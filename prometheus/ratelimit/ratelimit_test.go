@@ -0,0 +1,151 @@
+package ratelimit
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/YaoZengzeng/practice/prometheus/auth"
+
+	"github.com/astaxie/beego"
+	beecontext "github.com/astaxie/beego/context"
+)
+
+func TestLoadLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ratelimit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "limits.yaml")
+	for _, c := range []struct {
+		content string
+		valid   bool
+	}{
+		{"default:\n  user: {rate: 10, burst: 20}\nroutes:\n  /a/:b:\n    cluster: {rate: 0.5, burst: 1}\n", true},
+		{"default:\n  user: {rate: 0}\n", true},
+		{"default:\n  user: {rate: -1, burst: 1}\n", false},
+		{"default:\n  user: {rate: 1}\n", false},
+		{"routes:\n  /a/:b:\n    cluster: {rate: 1, burst: 0}\n", false},
+		{"default:\n  caller: {rate: 1, burst: 1}\n", false},
+	} {
+		if err := ioutil.WriteFile(filename, []byte(c.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadLimits(filename); (err == nil) != c.valid {
+			t.Errorf("limits %q: expected valid %v, got error %v", c.content, c.valid, err)
+		}
+	}
+}
+
+func limitedRequest(f beego.FilterFunc, user, cluster string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/backend/prometheus/clusters/"+cluster+"/namespaces", nil)
+	w := httptest.NewRecorder()
+	ctx := beecontext.NewContext()
+	ctx.Reset(w, r)
+	ctx.Input.SetParam(":cluster", cluster)
+	if user != "" {
+		ctx.Input.SetData("User", &auth.UserInfo{Name: user})
+	}
+
+	f(ctx)
+	return w
+}
+
+func TestFilter(t *testing.T) {
+	f := NewLimiter().Filter(defaultLimits, RouteLimits{
+		User:    &Limit{Rate: 0.1, Burst: 2},
+		Cluster: &Limit{Rate: 0.1, Burst: 3},
+	})
+
+	for i, c := range []struct {
+		user    string
+		cluster string
+		code    int
+	}{
+		{"alice", "ca", http.StatusOK},
+		{"alice", "ca", http.StatusOK},
+		// alice runs out of her tokens, in any cluster.
+		{"alice", "ca", http.StatusTooManyRequests},
+		{"alice", "cb", http.StatusTooManyRequests},
+		// The anonymous callers are identified by IP.
+		{"", "ca", http.StatusOK},
+		// ca runs out of its tokens.
+		{"bob", "ca", http.StatusTooManyRequests},
+		{"bob", "cb", http.StatusOK},
+		// bob isn't charged for the request rejected by ca.
+		{"bob", "cb", http.StatusOK},
+	} {
+		w := limitedRequest(f, c.user, c.cluster)
+		if w.Code != c.code {
+			t.Errorf("request %d of %q to %s: expected status code %d, got %d", i, c.user, c.cluster, c.code, w.Code)
+		}
+		if c.code == http.StatusTooManyRequests {
+			// A token is refilled every 10s.
+			if retryAfter := w.Header().Get("Retry-After"); retryAfter != "10" {
+				t.Errorf("request %d of %q to %s: expected Retry-After 10, got %q", i, c.user, c.cluster, retryAfter)
+			}
+		}
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	l := NewLimiter()
+	limit := &Limit{Rate: 1, Burst: 1}
+	now := time.Now()
+
+	l.allow("a", limit, now)
+	l.allow("b", limit, now.Add(idleTimeout))
+	l.allow("b", limit, now.Add(idleTimeout+sweepInterval+time.Second))
+
+	if _, ok := l.buckets["a"]; ok {
+		t.Errorf("expected the idle bucket to be removed")
+	}
+	if _, ok := l.buckets["b"]; !ok {
+		t.Errorf("expected the bucket in use to be kept")
+	}
+}
+
+type testController struct {
+	beego.Controller
+}
+
+func (c *testController) Get() {
+	c.Ctx.WriteString("ok")
+}
+
+func TestInit(t *testing.T) {
+	beego.Router("/backend/prometheus/clusters/:cluster/namespaces", &testController{})
+	beego.Router("/backend/prometheus/clusters/:cluster/namespaces/:namespace/pods/:pod/series", &testController{})
+	Init(&Limits{
+		Default: RouteLimits{User: &Limit{Rate: 0.1, Burst: 2}},
+		Routes: map[string]RouteLimits{
+			"/backend/prometheus/clusters/:cluster/namespaces/:namespace/pods/:pod/series": {
+				User: &Limit{Rate: 0.1, Burst: 1},
+			},
+		},
+	})
+
+	for i, c := range []struct {
+		path string
+		code int
+	}{
+		{"/backend/prometheus/clusters/ca/namespaces/default/pods/foo/series", http.StatusOK},
+		{"/backend/prometheus/clusters/ca/namespaces/default/pods/bar/series", http.StatusTooManyRequests},
+		// The series route has its own buckets.
+		{"/backend/prometheus/clusters/ca/namespaces", http.StatusOK},
+		{"/backend/prometheus/clusters/cb/namespaces", http.StatusOK},
+		{"/backend/prometheus/clusters/ca/namespaces", http.StatusTooManyRequests},
+	} {
+		w := httptest.NewRecorder()
+		beego.BeeApp.Handlers.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		if w.Code != c.code {
+			t.Errorf("request %d to %s: expected status code %d, got %d", i, c.path, c.code, w.Code)
+		}
+	}
+}
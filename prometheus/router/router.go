@@ -1,16 +1,137 @@
 package routers
 
 import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/YaoZengzeng/practice/prometheus/auth"
+	"github.com/YaoZengzeng/practice/prometheus/config"
 	"github.com/YaoZengzeng/practice/prometheus/controller"
+	"github.com/YaoZengzeng/practice/prometheus/metrics"
+	"github.com/YaoZengzeng/practice/prometheus/ratelimit"
 
 	"github.com/astaxie/beego"
+	beecontext "github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/logs"
+)
+
+// The authorization of the routes, the queries of pods and namespaces read them
+// whatever the methods of the requests are.
+var (
+	getPod         = &auth.Route{Resource: "pods", Name: ":pod", Verbs: map[string]string{"*": "get"}}
+	listPods       = &auth.Route{Resource: "pods", Verbs: map[string]string{"*": "list"}}
+	podRecords     = &auth.Route{Resource: "pods", Name: ":pod", Verbs: map[string]string{http.MethodGet: "get", "*": "update"}}
+	getNode        = &auth.Route{Resource: "nodes", Name: ":node", Verbs: map[string]string{"*": "get"}}
+	listNamespaces = &auth.Route{Resource: "namespaces", Verbs: map[string]string{http.MethodGet: "list"}}
+	rules          = &auth.Route{Group: "monitoring.coreos.com", Resource: "prometheusrules",
+		Verbs: map[string]string{http.MethodGet: "list", http.MethodPost: "create"}}
+	rule = &auth.Route{Group: "monitoring.coreos.com", Resource: "prometheusrules", Name: ":rule",
+		Verbs: map[string]string{http.MethodGet: "get", http.MethodPut: "update", http.MethodDelete: "delete"}}
 )
 
-func init() {
-	controller := controller.NewPrometheusController()
-	beego.Router("/backend/prometheus/clusters/:cluster/namespaces/:namespace/pods/:pod", controller, "*:MonitorPod")
-	beego.Router("/backend/prometheus/clusters/:cluster/namespaces/:namespace/pods/:pod/metrics", controller, "*:PodMetrics")
-	beego.Router("/backend/prometheus/clusters/:cluster/namespaces/:namespace/pods/:pod/metrics-records", controller, "*:PodMetricsRecords")
-	beego.Router("/backend/prometheus/clusters/:cluster/namespaces/:namespace/pods/:pod/series", controller, "*:PodSeries")
-	beego.Router("/backend/prometheus/clusters/:cluster/nodes/:node", controller, "*:MonitorNode")
+// routes are the routes of controller with their authorization.
+var routes = []struct {
+	pattern  string
+	mappings string
+	auth     *auth.Route
+}{
+	{"/backend/prometheus/clusters/:cluster/namespaces/:namespace/pods/:pod", "*:MonitorPod", getPod},
+	{"/backend/prometheus/clusters/:cluster/namespaces/:namespace/pods/:pod/metrics", "*:PodMetrics", getPod},
+	{"/backend/prometheus/clusters/:cluster/namespaces/:namespace/pods/:pod/metrics-records", "get:ListPodMetricsRecords;*:PodMetricsRecords", podRecords},
+	{"/backend/prometheus/clusters/:cluster/namespaces/:namespace/pods/:pod/series", "*:PodSeries", getPod},
+	{"/backend/prometheus/clusters/:cluster/nodes/:node", "*:MonitorNode", getNode},
+	{"/backend/prometheus/clusters/:cluster/namespaces", "get:Namespaces", listNamespaces},
+	{"/backend/prometheus/clusters/:cluster/namespaces/:namespace/pods", "get:Pods", listPods},
+	{"/backend/prometheus/clusters/:cluster/namespaces/:namespace/pods/:pod/metric-names", "get:PodMetricNames", getPod},
+	{"/backend/prometheus/clusters/:cluster/namespaces/:namespace/pods/:pod/labels/:label/values", "get:PodLabelValues", getPod},
+	{"/backend/prometheus/clusters/:cluster/namespaces/:namespace/query", "*:NamespaceQuery", listPods},
+	{"/backend/prometheus/clusters/:cluster/namespaces/:namespace/query_range", "*:NamespaceQueryRange", listPods},
+
+	{"/backend/prometheus/clusters/:cluster/namespaces/:namespace/rules", "get:ListRules;post:CreateRule", rules},
+	{"/backend/prometheus/clusters/:cluster/namespaces/:namespace/rules/:rule", "get:GetRule;put:UpdateRule;delete:DeleteRule", rule},
+	{"/backend/prometheus/clusters/:cluster/namespaces/:namespace/alerts", "get:Alerts", listPods},
+	{"/backend/prometheus/clusters/:cluster/namespaces/:namespace/alerting-rules", "get:AlertingRules", listPods},
+	{"/backend/prometheus/clusters/:cluster/namespaces/:namespace/pods/:pod/alerts", "get:Alerts", getPod},
+	{"/backend/prometheus/clusters/:cluster/namespaces/:namespace/pods/:pod/alerting-rules", "get:AlertingRules", getPod},
+}
+
+// Init registers the routes, it should be called after the config is loaded.
+func Init() error {
+	store, err := controller.NewStore(config.Get().Store.Kind, config.Get().Store.DSN)
+	if err != nil {
+		return err
+	}
+
+	controller, err := controller.NewPrometheusController(store)
+	if err != nil {
+		return err
+	}
+
+	// The routes are marked before the filters of auth and rate limits, so the
+	// requests rejected by them are authorized and recorded by their routes.
+	for _, r := range routes {
+		beego.Router(r.pattern, controller, r.mappings)
+		beego.InsertFilter(r.pattern, beego.BeforeExec, metrics.RouteFilter(r.pattern), true, true)
+		beego.InsertFilter(r.pattern, beego.BeforeExec, auth.RouteFilter(r.auth), true, true)
+	}
+
+	if err := initAuth(controller); err != nil {
+		return err
+	}
+	// The rate limits identify the callers authenticated by the filter of auth.
+	if config.Get().RateLimitsFile != "" {
+		limits, err := ratelimit.LoadLimits(config.Get().RateLimitsFile)
+		if err != nil {
+			return err
+		}
+		ratelimit.Init(limits)
+	}
+
+	return nil
+}
+
+// initAuth inserts the filter which authenticates and authorizes the callers, the
+// reviews are sent to the API servers of the clusters of controller. The filter is
+// rebuilt once the config is reloaded, so the token and policy files are read again.
+func initAuth(controller *controller.PrometheusController) error {
+	clients := func(cluster string) (auth.KubeClient, error) {
+		client, err := controller.KubeClient(cluster)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+
+	var filter atomic.Value
+	build := func(c *config.Config) error {
+		authn, err := auth.NewAuthenticator(c.Auth.Mode, c.Auth.TokenFile, clients, c.Auth.CacheTTL)
+		if err != nil {
+			return err
+		}
+		authz, err := auth.NewAuthorizer(c.Auth.AuthzMode, c.Auth.PolicyFile, clients, c.Auth.CacheTTL)
+		if err != nil {
+			return err
+		}
+
+		if authn == nil {
+			filter.Store(beego.FilterFunc(func(*beecontext.Context) {}))
+		} else {
+			filter.Store(auth.Filter(authn, authz))
+		}
+		return nil
+	}
+
+	if err := build(config.Get()); err != nil {
+		return err
+	}
+	config.OnReload(func(old, new *config.Config) {
+		if err := build(new); err != nil {
+			logs.Error("Rebuild auth filter failed, the old one is kept: %v", err)
+		}
+	})
+
+	beego.InsertFilter("/backend/prometheus/*", beego.BeforeExec, func(ctx *beecontext.Context) {
+		filter.Load().(beego.FilterFunc)(ctx)
+	}, true, true)
+	return nil
 }
@@ -1,3 +1,9 @@
 #!/bin/sh
 
 curl http://127.0.0.1:8080/backend/prometheus/clusters/test/namespaces/default/pods/prometheus-6f5d56767b-vbvwd/metrics
+curl http://127.0.0.1:8080/backend/prometheus/clusters/test/namespaces/default/pods/prometheus-6f5d56767b-vbvwd/metrics-records
+curl "http://127.0.0.1:8080/backend/prometheus/clusters/test/namespaces/default/pods/prometheus-6f5d56767b-vbvwd/series?start=1556018614&end=1556018914&step=15s"
+curl http://127.0.0.1:8080/backend/prometheus/clusters/test/namespaces/default/pods
+curl -X POST -d '{"name": "pod-down", "groups": [{"name": "pods", "rules": [{"alert": "PodDown", "expr": "up == 0", "for": "5m"}]}]}' http://127.0.0.1:8080/backend/prometheus/clusters/test/namespaces/default/rules
+curl http://127.0.0.1:8080/backend/prometheus/clusters/test/namespaces/default/rules
+curl http://127.0.0.1:8080/backend/prometheus/clusters/test/namespaces/default/pods/prometheus-6f5d56767b-vbvwd/alerts